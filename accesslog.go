@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// responseRecorder wraps an http.ResponseWriter to capture the status code
+// and number of body bytes written.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logAccess writes an access log line in Common or Combined Log Format for
+// every request.
+func logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user := "-"
+		if name, _, ok := r.BasicAuth(); ok && name != "" {
+			user = name
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		size := "-"
+		if rec.bytes > 0 {
+			size = strconv.FormatInt(rec.bytes, 10)
+		}
+
+		line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+			host, user, start.In(location).Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, status, size)
+		if accessLogFormat == "combined" {
+			line += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+		}
+		accessLogger.Print(line)
+	})
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogAccessAtAnyLevel(t *testing.T) {
+	setupTest(t)
+	defer func(format string) { accessLogFormat = format }(accessLogFormat)
+	accessLogFormat = "common"
+	handler := logAccess(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	for _, format := range []string{"plain", "text", "json"} {
+		for _, level := range []slog.Level{slog.LevelDebug, slog.LevelWarn, slog.LevelError} {
+			var out strings.Builder
+			setLoggers(&out, format, level)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/today", nil))
+			// The request is logged even if info messages are discarded
+			if !strings.Contains(out.String(), "/today") || !strings.Contains(out.String(), " 200 2") {
+				t.Errorf("%s format at level %s logged %q", format, level, out.String())
+			}
+		}
+	}
+}
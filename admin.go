@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin wraps a handler so it only runs for requests carrying the
+// configured admin token as a bearer token.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// serveRescan rebuilds the ImageMapper from imageDir without touching
+// today's image, so newly added files are considered for future dates. The
+// directory is walked again even if unchanged, to catch files rewritten in
+// place.
+func serveRescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	if !primary.lock("rescanning") {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "an image update is still in progress"})
+		return
+	}
+	defer primary.unlock()
+
+	forgetScans()
+	images, err := loadImages()
+	if err != nil {
+		errorLogger.Printf("Error rescanning image directory: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	primary.store(images)
+
+	logger.Printf("Rescanned image directory: %d images", len(images))
+	writeJSON(w, http.StatusOK, map[string]int{"images": len(images)})
+}
+
+// serveRefresh rescans the image directory and renews today's image right
+// away, as the scheduler would, and responds with the newly selected image.
+func serveRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	forgetScans()
+	if !primary.update() {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "updating today's image failed"})
+		return
+	}
+	info, _ := currentTodayInfo()
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeRescan(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dir string)
+		want   map[string]int64 // Size by filename
+	}{
+		{
+			name:   "unchanged",
+			change: func(t *testing.T, dir string) {},
+			want:   map[string]int64{"a.jpg": 1, "b.jpg": 2},
+		},
+		{
+			name: "added",
+			change: func(t *testing.T, dir string) {
+				writeTestFile(t, dir, "c.jpg", []byte("ccc"))
+			},
+			want: map[string]int64{"a.jpg": 1, "b.jpg": 2, "c.jpg": 3},
+		},
+		{
+			// Rewriting a file doesn't touch its directory, so only a
+			// fresh walk notices
+			name: "rewritten in place",
+			change: func(t *testing.T, dir string) {
+				writeTestFile(t, dir, "a.jpg", []byte("aaaa"))
+			},
+			want: map[string]int64{"a.jpg": 4, "b.jpg": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			defer func(c *collection, dir string) { primary, imageDir = c, dir }(primary, imageDir)
+			imageDir = t.TempDir()
+			writeTestFile(t, imageDir, "a.jpg", []byte("a"))
+			writeTestFile(t, imageDir, "b.jpg", []byte("bb"))
+			// Old enough for the scan to be reused
+			past := time.Now().Add(-time.Hour)
+			if err := os.Chtimes(imageDir, past, past); err != nil {
+				t.Fatal(err)
+			}
+			primary = newCollection("", imageDir, assetDir)
+			images, err := loadImages()
+			if err != nil {
+				t.Fatal(err)
+			}
+			primary.store(images)
+
+			tt.change(t, imageDir)
+			w := httptest.NewRecorder()
+			serveRescan(w, httptest.NewRequest(http.MethodPost, "/api/rescan", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			got := make(map[string]int64)
+			for _, info := range *primary.infos.Load() {
+				got[info.Filename] = info.Size
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("images = %v, want %v", got, tt.want)
+			}
+			for name, size := range tt.want {
+				if got[name] != size {
+					t.Errorf("%s: size = %d, want %d", name, got[name], size)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkScanImages compares walking an image directory with reusing the
+// last scan of it, which only stats its directories.
+func BenchmarkScanImages(b *testing.B) {
+	setupTest(b)
+	dir := b.TempDir()
+	past := time.Now().Add(-time.Hour)
+	for i := 0; i < 20; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("album%02d", i))
+		for j := 0; j < 50; j++ {
+			writeTestFile(b, sub, fmt.Sprintf("monkey%02d.jpg", j), []byte("not a jpeg"))
+		}
+		if err := os.Chtimes(sub, past, past); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(dir, past, past); err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarks := []struct {
+		name  string
+		fresh bool
+	}{
+		{"walk", true},
+		{"cached", false},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			forgetScans()
+			defer forgetScans()
+			for i := 0; i < b.N; i++ {
+				if bm.fresh {
+					forgetScans()
+				}
+				if _, err := scanImages(dir); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+
+	defaultUpcomingDays = 7
+	maxUpcomingDays     = 60
+)
+
+// serveImageList returns the metadata of the images in the pool, as cached
+// by the last scan. Results are paginated with the offset and limit query
+// parameters; the total count is sent in the X-Total-Count header.
+func serveImageList(w http.ResponseWriter, r *http.Request) {
+	var images []imageInfo
+	if infos := primary.infos.Load(); infos != nil {
+		images = *infos
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid offset"})
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageSize)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+		return
+	}
+
+	// offset+limit could overflow, so the end is counted from the start
+	start := min(offset, len(images))
+	end := start + min(limit, len(images)-start)
+	page := images[start:end]
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(images)))
+	writeJSON(w, http.StatusOK, page)
+}
+
+// upcomingImage is an entry of /api/upcoming.
+type upcomingImage struct {
+	Date     string `json:"date"`
+	Filename string `json:"filename"`
+}
+
+// serveUpcoming lists the images selected for the number of days given by
+// the days query parameter, starting today.
+func serveUpcoming(w http.ResponseWriter, r *http.Request) {
+	days, err := queryInt(r, "days", defaultUpcomingDays)
+	if err != nil || days < 1 || days > maxUpcomingDays {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid days"})
+		return
+	}
+	mapper := primary.mapper.Load()
+	if mapper == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no images available"})
+		return
+	}
+
+	today := time.Now().In(location)
+	upcoming := make([]upcomingImage, 0, days)
+	for i := range days {
+		date := today.AddDate(0, 0, i)
+		selectedImage, err := previewImage(mapper, date, region)
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		upcoming = append(upcoming, upcomingImage{Date: date.Format("2006-01-02"), Filename: selectedImage})
+	}
+	writeJSON(w, http.StatusOK, upcoming)
+}
+
+// serveWeekInfo describes this week's image.
+func serveWeekInfo(w http.ResponseWriter, r *http.Request) {
+	date := selectionDate()
+	selectedImage, err := selectWeekImage(date)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no image available"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"week":     isoWeek(date),
+		"filename": selectedImage,
+		"url":      "/week",
+	})
+}
+
+// todayInfo describes the currently served image.
+type todayInfo struct {
+	Filename string `json:"filename"`
+	Date     string `json:"date"`
+	URL      string `json:"url"`
+	Timezone string `json:"timezone"`
+	Stale    bool   `json:"stale"`
+	Link     string `json:"link,omitempty"`
+
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	NextOccurrence *string `json:"next_occurrence"` // Period label, null if not within nextOccurrenceDays
+	RefreshSeconds int     `json:"refresh_seconds"` // Until the next image, as the page waits
+}
+
+// currentTodayInfo returns the description of the currently served image,
+// or false if there is none yet.
+func currentTodayInfo() (todayInfo, bool) {
+	img := primary.image()
+	if img.filename == "" {
+		return todayInfo{}, false
+	}
+	return todayInfo{
+		Filename: img.source,
+		Date:     img.date,
+		URL:      "/assets/" + img.filename,
+		Timezone: timezoneName,
+		Stale:    img.isStale(),
+		Link:     linkFor(img.source),
+
+		Title:       img.caption.Title,
+		Description: img.caption.Description,
+
+		NextOccurrence: cachedNextOccurrence(primary.mapper.Load(), img.time, img.source),
+		RefreshSeconds: refreshSeconds(time.Now()),
+	}, true
+}
+
+// nextOccurrenceDays is how far ahead nextOccurrence looks.
+const nextOccurrenceDays = 365
+
+// nextOccurrence returns the label, as in periodLabel, of the first period
+// after the one containing from in which mapper selects name, or nil if
+// that's not within nextOccurrenceDays.
+func nextOccurrence(mapper *ImageMapper, from time.Time, name string) *string {
+	current := periodLabel(from)
+	for day := from; day.Sub(from) <= nextOccurrenceDays*24*time.Hour; day = day.AddDate(0, 0, 1) {
+		for _, start := range periodStarts(day) {
+			label := periodLabel(start)
+			if label <= current {
+				continue
+			}
+			selectedImage, err := previewImage(mapper, start, region)
+			if err != nil {
+				return nil
+			}
+			if selectedImage == name {
+				return &label
+			}
+		}
+	}
+	return nil
+}
+
+// The last result of nextOccurrence, which takes a hash per image and
+// period, so it's only computed once per selection.
+var (
+	occurrenceMutex sync.Mutex
+	occurrenceKey   occurrenceQuery
+	occurrenceNext  *string
+)
+
+type occurrenceQuery struct {
+	mapper *ImageMapper
+	label  string
+	name   string
+}
+
+// cachedNextOccurrence returns nextOccurrence, computed at most once per
+// mapper and selection.
+func cachedNextOccurrence(mapper *ImageMapper, from time.Time, name string) *string {
+	if mapper == nil {
+		return nil
+	}
+	occurrenceMutex.Lock()
+	defer occurrenceMutex.Unlock()
+
+	key := occurrenceQuery{mapper: mapper, label: periodLabel(from), name: name}
+	if key != occurrenceKey {
+		occurrenceKey = key
+		occurrenceNext = nextOccurrence(mapper, from, name)
+	}
+	return occurrenceNext
+}
+
+// serveTodayInfo writes the description of the currently served image.
+func serveTodayInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := currentTodayInfo()
+	if !ok {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no image available"})
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// prefersJSON reports whether the Accept header of r ranks application/json
+// above text/html. Wildcards count for both, so browsers get HTML.
+func prefersJSON(r *http.Request) bool {
+	var jsonQ, htmlQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/json", "application/*":
+			jsonQ = max(jsonQ, q)
+		case "text/html", "text/*":
+			htmlQ = max(htmlQ, q)
+		case "*/*":
+			jsonQ, htmlQ = max(jsonQ, q), max(htmlQ, q)
+		}
+	}
+	return jsonQ > htmlQ
+}
+
+// queryInt parses the query parameter key as an integer, returning fallback
+// if it's absent.
+func queryInt(r *http.Request, key string, fallback int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeImageList(t *testing.T) {
+	setupTest(t)
+	defer func(c *collection, dir string) { primary, imageDir = c, dir }(primary, imageDir)
+	imageDir = t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		writeTestJPEG(t, filepath.Join(imageDir, name))
+	}
+	primary = newCollection("", imageDir, assetDir)
+	primary.update()
+
+	tests := []struct {
+		query    string
+		wantCode int
+		want     []string
+	}{
+		{"", http.StatusOK, []string{"a.jpg", "b.jpg", "c.jpg"}},
+		{"?offset=1&limit=1", http.StatusOK, []string{"b.jpg"}},
+		{"?offset=2&limit=5", http.StatusOK, []string{"c.jpg"}},
+		{"?offset=3", http.StatusOK, []string{}},
+		{"?offset=9223372036854775807&limit=1", http.StatusOK, []string{}},
+		{"?offset=1&limit=" + strconv.Itoa(maxPageSize), http.StatusOK, []string{"b.jpg", "c.jpg"}},
+		{"?offset=-1", http.StatusBadRequest, nil},
+		{"?offset=9223372036854775808", http.StatusBadRequest, nil},
+		{"?limit=0", http.StatusBadRequest, nil},
+		{"?limit=" + strconv.Itoa(maxPageSize+1), http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serveImageList(w, httptest.NewRequest(http.MethodGet, "/api/images"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var page []imageInfo
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+			if got := imageNames(page); !slices.Equal(got, tt.want) {
+				t.Errorf("/api/images%s = %v, want %v", tt.query, got, tt.want)
+			}
+			if got := w.Header().Get("X-Total-Count"); got != "3" {
+				t.Errorf("X-Total-Count = %q, want 3", got)
+			}
+		})
+	}
+}
+
+func TestServeWeekInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		images   []string
+		wantCode int
+	}{
+		{"images", []string{"a.jpg", "b.jpg", "c.jpg"}, http.StatusOK},
+		{"no images", nil, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			defer func(c *collection, dir string) { primary, imageDir = c, dir }(primary, imageDir)
+			imageDir = t.TempDir()
+			for _, name := range tt.images {
+				writeTestJPEG(t, filepath.Join(imageDir, name))
+			}
+			primary = newCollection("", imageDir, assetDir)
+			primary.update()
+
+			w := httptest.NewRecorder()
+			serveWeekInfo(w, httptest.NewRequest(http.MethodGet, "/api/week", nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now().In(location)
+			want, err := primary.mapper.Load().GetImageForWeek(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["week"] != isoWeek(now) || got["filename"] != want || got["url"] != "/week" {
+				t.Errorf("/api/week = %v, want week %s, filename %s and url /week", got, isoWeek(now), want)
+			}
+		})
+	}
+}
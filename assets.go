@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// caseInsensitiveDir is an http.FileSystem that falls back to a
+// case-insensitive match when a name does not exist verbatim. Ambiguous
+// matches are treated as not found.
+type caseInsensitiveDir struct {
+	http.Dir
+}
+
+func (d caseInsensitiveDir) Open(name string) (http.File, error) {
+	f, err := d.Dir.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+
+	dir, base := path.Split(path.Clean("/" + name))
+	entries, readErr := os.ReadDir(filepath.Join(string(d.Dir), filepath.FromSlash(dir)))
+	if readErr != nil {
+		return nil, err
+	}
+
+	match := ""
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Name(), base) {
+			if match != "" {
+				return nil, err
+			}
+			match = entry.Name()
+		}
+	}
+	if match == "" {
+		return nil, err
+	}
+	return d.Dir.Open(path.Join(dir, match))
+}
+
+// normalizeAssetPath redirects case variants of the /assets prefix, like
+// /Assets/x.jpg, to their canonical spelling.
+func normalizeAssetPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if prefix != "assets" && strings.EqualFold(prefix, "assets") {
+			target := "/assets/" + rest
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cacheAssets serves today's image in the asset directory with daily
+// caching headers, from wherever it's kept, so it works with -no-copy too.
+// Everything else is passed through unchanged.
+func cacheAssets(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if img := primary.image(); img.filename != "" && strings.TrimPrefix(r.URL.Path, "/") == img.filename {
+			setDailyCacheHeaders(w, time.Now(), img.etag)
+			serveImageFile(w, r, img.path)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setDailyCacheHeaders lets clients cache today's image, tagged etag, until
+// the next renewal at now. http.ServeContent answers If-None-Match with 304
+// based on the ETag set here.
+func setDailyCacheHeaders(w http.ResponseWriter, now time.Time, etag string) {
+	renewal := nextRenewal(now)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(renewal.Sub(now).Seconds())))
+	w.Header().Set("Expires", renewal.UTC().Format(http.TimeFormat))
+}
+
+// serveToday serves today's image at a stable URL. With a region query
+// parameter, today's image in that region's sequence is served instead. The
+// w, h and format query parameters request a resized or converted variant.
+func serveToday(w http.ResponseWriter, r *http.Request) {
+	var path string
+	key := r.URL.Query().Get("region")
+	if key == "" || key == region {
+		img := primary.image()
+		if img.filename == "" {
+			http.NotFound(w, r)
+			return
+		}
+		path = img.path
+	} else {
+		selectedImage, err := selectRegionImage(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if path, err = sourcePath(selectedImage); err != nil {
+			errorLogger.Printf("Error fetching %s: %v", selectedImage, err)
+			http.Error(w, "image unavailable", http.StatusBadGateway)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", todayCacheHeader(time.Now()))
+	serveImageOrVariant(w, r, path)
+}
+
+// serveImageOrVariant serves the image at path, or the variant requested by
+// the w, h and format query parameters if any is given.
+func serveImageOrVariant(w http.ResponseWriter, r *http.Request, path string) {
+	query := r.URL.Query()
+	if query.Has("format") || query.Has("w") || query.Has("h") {
+		width, errW := queryInt(r, "w", 0)
+		height, errH := queryInt(r, "h", 0)
+		if errW != nil || errH != nil {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+		serveVariant(w, r, path, width, height, query.Get("format"))
+		return
+	}
+	serveImageFile(w, r, path)
+}
+
+// maxTodayAge is the longest time /today may be cached by default.
+const maxTodayAge = 5 * time.Minute
+
+// todayCacheHeader returns the Cache-Control header for /today at now: the
+// configured one, or by default a max-age that expires by the next renewal.
+func todayCacheHeader(now time.Time) string {
+	if todayCacheControl != "" {
+		return todayCacheControl
+	}
+	age := min(maxTodayAge, nextRenewal(now).Sub(now))
+	return fmt.Sprintf("public, max-age=%d", int(age.Seconds()))
+}
+
+// serveDateImage serves the image for the date in the path, e.g.
+// /image/2023-07-04.
+func serveDateImage(w http.ResponseWriter, r *http.Request) {
+	date, err := time.ParseInLocation("2006-01-02", strings.TrimPrefix(r.URL.Path, "/image/"), location)
+	if err != nil {
+		http.Error(w, "invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	mapper := primary.mapper.Load()
+	if mapper == nil {
+		http.Error(w, "no images available", http.StatusServiceUnavailable)
+		return
+	}
+	selectedImage, err := selectImage(mapper, date, region)
+	switch {
+	case errors.Is(err, ErrFutureDate), errors.Is(err, ErrBeforeEpoch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	path, err := sourcePath(selectedImage)
+	if err != nil {
+		errorLogger.Printf("Error fetching %s: %v", selectedImage, err)
+		http.Error(w, "image unavailable", http.StatusBadGateway)
+		return
+	}
+	serveImageFile(w, r, path)
+}
+
+// serveWeek serves this week's image at a stable URL.
+func serveWeek(w http.ResponseWriter, r *http.Request) {
+	selectedImage, err := selectWeekImage(selectionDate())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	path, err := sourcePath(selectedImage)
+	if err != nil {
+		errorLogger.Printf("Error fetching %s: %v", selectedImage, err)
+		http.Error(w, "image unavailable", http.StatusBadGateway)
+		return
+	}
+	serveImageFile(w, r, path)
+}
+
+// serveRandom serves a random image from the pool on every request, for
+// rotating through it independently of the daily selection.
+func serveRandom(w http.ResponseWriter, r *http.Request) {
+	var images []string
+	if mapper := primary.mapper.Load(); mapper != nil {
+		images = mapper.Images()
+	}
+	if len(images) == 0 {
+		http.Error(w, "no images available", http.StatusServiceUnavailable)
+		return
+	}
+	selectedImage := images[randomInt64N(int64(len(images)))]
+	path, err := sourcePath(selectedImage)
+	if err != nil {
+		errorLogger.Printf("Error fetching %s: %v", selectedImage, err)
+		http.Error(w, "image unavailable", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	serveImageFile(w, r, path)
+}
+
+// selectWeekImage picks the image for the week containing date from the
+// current mapper.
+func selectWeekImage(date time.Time) (string, error) {
+	mapper := primary.mapper.Load()
+	if mapper == nil {
+		return "", errors.New("no images available")
+	}
+	return mapper.GetImageForWeek(date)
+}
+
+// selectRegionImage picks the current image in the sequence of key from the
+// current mapper.
+func selectRegionImage(key string) (string, error) {
+	mapper := primary.mapper.Load()
+	if mapper == nil {
+		return "", errors.New("no images available")
+	}
+	return selectImage(mapper, selectionDate(), key)
+}
+
+// serveImageFile serves the file at path with http.ServeContent, so
+// conditional and Range requests are answered from its modification time
+// and contents. The Content-Type is sniffed from the contents rather than
+// trusted from the extension, so a renamed file is still served correctly.
+func serveImageFile(w http.ResponseWriter, r *http.Request, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		errorLogger.Printf("Error opening %s: %v", path, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if contentType := sniffMediaType(file); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// sniffMediaType returns the image or video type detected from the first
+// bytes of file, or "" if they aren't recognized, leaving it to the
+// extension. The file is rewound afterwards.
+func sniffMediaType(file io.ReadSeeker) string {
+	var header [512]byte
+	n, _ := io.ReadFull(file, header[:])
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+	contentType := http.DetectContentType(header[:n])
+	if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") {
+		return contentType
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeImageFile(t *testing.T) {
+	setupTest(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "monkey.jpg")
+	writeTestJPEG(t, path)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len(content)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		header   map[string]string
+		wantCode int
+		want     []byte // Nil to skip checking the body
+	}{
+		{"whole", path, nil, http.StatusOK, content},
+		{"first bytes", path, map[string]string{"Range": "bytes=0-7"}, http.StatusPartialContent, content[:8]},
+		{"middle", path, map[string]string{"Range": "bytes=8-15"}, http.StatusPartialContent, content[8:16]},
+		{"suffix", path, map[string]string{"Range": "bytes=-4"}, http.StatusPartialContent, content[size-4:]},
+		{"open ended", path, map[string]string{"Range": "bytes=10-"}, http.StatusPartialContent, content[10:]},
+		{"past the end", path, map[string]string{"Range": "bytes=100000-"}, http.StatusRequestedRangeNotSatisfiable, nil},
+		{"stale range", path, map[string]string{"Range": "bytes=0-7", "If-Range": modTime.Add(-time.Hour).UTC().Format(http.TimeFormat)}, http.StatusOK, content},
+		{"not modified", path, map[string]string{"If-Modified-Since": modTime.UTC().Format(http.TimeFormat)}, http.StatusNotModified, nil},
+		{"missing", filepath.Join(dir, "missing.jpg"), nil, http.StatusNotFound, nil},
+		{"directory", dir, nil, http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/today", nil)
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			serveImageFile(w, r, tt.path)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.want != nil && !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Errorf("body = %x, want %x", w.Body.Bytes(), tt.want)
+			}
+			if w.Code == http.StatusOK || w.Code == http.StatusPartialContent {
+				if got := w.Header().Get("Content-Type"); got != "image/jpeg" {
+					t.Errorf("Content-Type = %q, want image/jpeg", got)
+				}
+				if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+					t.Errorf("Accept-Ranges = %q, want bytes", got)
+				}
+			}
+		})
+	}
+}
+
+func TestServeTodayRange(t *testing.T) {
+	setupTest(t)
+	defer func(c *collection, dir string) { primary, imageDir = c, dir }(primary, imageDir)
+	imageDir = t.TempDir()
+	writeTestJPEG(t, filepath.Join(imageDir, "a.jpg"))
+	primary = newCollection("", imageDir, assetDir)
+	if !primary.update() {
+		t.Fatal("update() failed")
+	}
+	content, err := os.ReadFile(primary.image().path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/today", nil)
+	r.Header.Set("Range", "bytes=1-3")
+	w := httptest.NewRecorder()
+	serveToday(w, r)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if !bytes.Equal(w.Body.Bytes(), content[1:4]) {
+		t.Errorf("body = %x, want %x", w.Body.Bytes(), content[1:4])
+	}
+	if got, want := w.Header().Get("Content-Range"), "bytes 1-3/"+strconv.Itoa(len(content)); got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// caption is the title and description shown under an image.
+type caption struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// readCaption returns the caption of the pool image name, read from a
+// sidecar file next to its local copy at imagePath: the same name with a
+// .json extension, holding a title and description, or a .txt extension,
+// whose first line is the title and the rest the description. Without a
+// usable sidecar, the title is the image's filename.
+func readCaption(imagePath, name string) caption {
+	fallback := caption{Title: path.Base(name)}
+	base := strings.TrimSuffix(imagePath, filepath.Ext(imagePath))
+
+	data, err := os.ReadFile(base + ".json")
+	if err == nil {
+		var c caption
+		if err := json.Unmarshal(data, &c); err != nil {
+			warnLogger.Printf("Skipping invalid caption file %s.json: %v", base, err)
+			return fallback
+		}
+		if c.Title == "" {
+			c.Title = fallback.Title
+		}
+		return c
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		errorLogger.Printf("Error reading caption of %s: %v", name, err)
+		return fallback
+	}
+
+	data, err = os.ReadFile(base + ".txt")
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			errorLogger.Printf("Error reading caption of %s: %v", name, err)
+		}
+		return fallback
+	}
+	title, description, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	if title = strings.TrimSpace(title); title == "" {
+		return fallback
+	}
+	return caption{Title: title, Description: strings.TrimSpace(description)}
+}
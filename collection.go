@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// collectionAssetDir is the subdirectory of assetDir that the assets of the
+// collections in -collections are kept in, one directory per collection.
+const collectionAssetDir = "collections"
+
+// collection is a set of images with an image of the day of its own. The
+// primary collection is the one in imageDir, served at /. With -collections,
+// every subdirectory of that directory is another collection, served at
+// /c/<name> and renewed independently.
+//
+// The index, pools, playlist, featured image, links and the region query
+// parameter only apply to the primary collection.
+type collection struct {
+	name     string // Empty for the primary collection, unless it's the default collection
+	imageDir string
+	assetDir string
+
+	mutex    chan struct{}                  // Mutex to prevent concurrent writes, see lock
+	lockedAt atomic.Int64                   // When mutex was last acquired, in Unix nanoseconds
+	mapper   atomic.Pointer[ImageMapper]    // Mapper built from the last scan
+	infos    atomic.Pointer[[]imageInfo]    // Metadata from the last scan, sorted by filename
+	current  atomic.Pointer[installedImage] // Today's image, nil until one is installed
+}
+
+// installedImage is today's image of a collection in its asset directory,
+// copied from the pool image source, with its thumbnail. With -no-copy,
+// filename only names the image in URLs and path is the source itself. It's
+// replaced as a whole by install and never modified once published, so
+// handlers can read it without locking.
+type installedImage struct {
+	filename      string
+	path          string
+	source        string
+	date          string    // Date (2006-01-02) the image was selected for
+	time          time.Time // Time the image was selected at
+	etag          string    // Derived from the sha256 of the image, empty if it couldn't be hashed
+	caption       caption
+	thumbFilename string
+	srcset        []srcsetImage // Scaled-down copies and the image itself, if offered in a srcset
+	favicon       []byte        // ICO of today's image, nil if there is none
+}
+
+// image returns today's image of c, or an empty one with no filename if
+// none has been installed yet.
+func (c *collection) image() *installedImage {
+	if img := c.current.Load(); img != nil {
+		return img
+	}
+	return &installedImage{}
+}
+
+// newCollection returns a collection of the images in imageDir, which keeps
+// its assets in assetDir.
+func newCollection(name, imageDir, assetDir string) *collection {
+	return &collection{
+		name:     name,
+		imageDir: imageDir,
+		assetDir: assetDir,
+		mutex:    make(chan struct{}, 1),
+	}
+}
+
+var (
+	// primary is the collection in imageDir.
+	primary *collection
+
+	// collections holds the collections found in -collections by name. With
+	// -default-collection, the primary collection is one of them.
+	collections map[string]*collection
+)
+
+// loadCollections sets up the primary collection and one collection per
+// subdirectory of collectionsDir, if set. Hidden directories are skipped.
+func loadCollections() error {
+	primary = newCollection("", imageDir, assetDir)
+	if collectionsDir == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(collectionsDir)
+	if err != nil {
+		return err
+	}
+	collections = make(map[string]*collection)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if name == defaultCollection {
+			primary.name = name
+			collections[name] = primary
+			continue
+		}
+		collections[name] = newCollection(name, filepath.Join(collectionsDir, name), filepath.Join(assetDir, collectionAssetDir, name))
+	}
+	if defaultCollection != "" && collections[defaultCollection] == nil {
+		return fmt.Errorf("default collection %q not found in %s", defaultCollection, collectionsDir)
+	}
+	return nil
+}
+
+// secondaryCollections returns the collections other than the primary one,
+// sorted by name.
+func secondaryCollections() []*collection {
+	var secondary []*collection
+	for _, c := range collections {
+		if c != primary {
+			secondary = append(secondary, c)
+		}
+	}
+	slices.SortFunc(secondary, func(a, b *collection) int { return strings.Compare(a.name, b.name) })
+	return secondary
+}
+
+// logPrefix returns the prefix of log messages about c, which is empty for
+// the primary collection.
+func (c *collection) logPrefix() string {
+	if c == primary {
+		return ""
+	}
+	return "[" + c.name + "] "
+}
+
+// load returns the images of c: for the primary collection as configured by
+// loadImages, otherwise those in its directory, weighted and scheduled by
+// its weights and schedule files.
+func (c *collection) load() ([]imageInfo, error) {
+	if c == primary {
+		return loadImages()
+	}
+	images, err := scanImages(c.imageDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyWeights(c.imageDir, images); err != nil {
+		return nil, err
+	}
+	if err := applySchedule(c.imageDir, images); err != nil {
+		return nil, err
+	}
+	if dedupe != "" {
+		images = dedupeImages(c.imageDir, images)
+	}
+	return images, nil
+}
+
+// store publishes freshly loaded images of c for selection and the API. For
+// the primary collection, the image links are reread along with them.
+func (c *collection) store(images []imageInfo) *ImageMapper {
+	sorted := slices.Clone(images)
+	slices.SortFunc(sorted, func(a, b imageInfo) int { return strings.Compare(a.Filename, b.Filename) })
+
+	var mapper *ImageMapper
+	if c == primary {
+		mapper = newMapper(sorted)
+		imagesFound.Set(float64(len(sorted)))
+		loadLinks()
+	} else {
+		mapper = NewImageMapper(imageNames(sorted)).WithWeights(imageWeights(sorted)).WithWindows(imageWindows(sorted)).WithSeed(seed).WithStrategy(selectionStrategy(sorted)).WithInterval(interval)
+	}
+	c.mapper.Store(mapper)
+	c.infos.Store(&sorted)
+	return mapper
+}
+
+// sourcePath returns the local path of the image name in c, see sourcePath.
+func (c *collection) sourcePath(name string) (string, error) {
+	if c == primary {
+		return sourcePath(name)
+	}
+	return filepath.Join(c.imageDir, filepath.FromSlash(name)), nil
+}
+
+// lock acquires the mutex of c for doing, e.g. "updating the image". If
+// that takes longer than -lock-timeout, e.g. because an update is stuck
+// copying from a hung network mount, it logs how long the mutex has been
+// held and returns false instead of waiting any longer.
+func (c *collection) lock(doing string) bool {
+	if lockTimeout > 0 {
+		timer := time.NewTimer(lockTimeout)
+		defer timer.Stop()
+		select {
+		case c.mutex <- struct{}{}:
+		case <-timer.C:
+			held := time.Since(time.Unix(0, c.lockedAt.Load())).Round(time.Second)
+			errorLogger.Printf("%sGave up %s: another update has been holding the lock for %v", c.logPrefix(), doing, held)
+			return false
+		}
+	} else {
+		c.mutex <- struct{}{}
+	}
+	c.lockedAt.Store(time.Now().UnixNano())
+	return true
+}
+
+// unlock releases the mutex of c acquired by lock.
+func (c *collection) unlock() {
+	<-c.mutex
+}
+
+// update selects today's image of c and puts it in place in its asset
+// directory. It reports whether that succeeded. If it didn't, the
+// -fallback image is put in place instead, if set.
+func (c *collection) update() bool {
+	if !c.lock("updating the image") {
+		return false
+	}
+	defer c.unlock()
+
+	logger.Printf("%sUpdating image for today...", c.logPrefix())
+	today := time.Now().In(location)
+
+	// Get list of images
+	images, err := c.load()
+	if err != nil {
+		errorLogger.Printf("%sError getting image list: %v", c.logPrefix(), err)
+		return c.useFallback(today)
+	}
+
+	if len(images) == 0 {
+		warnLogger.Printf("%sNo images available in the image directory", c.logPrefix())
+		return c.useFallback(today)
+	}
+
+	// Create ImageMapper
+	mapper := c.store(images)
+
+	// Get image for today, unless one is featured
+	var selectedImage string
+	if c == primary && featuredImage != "" && mapper.Contains(featuredImage) {
+		selectedImage = featuredImage
+		logger.Printf("Using featured image: %s", selectedImage)
+	} else {
+		if c == primary && featuredImage != "" {
+			warnLogger.Printf("Featured image %s is no longer in the pool, using the daily pick", featuredImage)
+		}
+		selectedImage, err = selectImage(mapper, today, region)
+		if err != nil {
+			if c == primary {
+				selectionErrors.Inc()
+			}
+			errorLogger.Printf("%sError selecting image for today: %v", c.logPrefix(), err)
+			return c.useFallback(today)
+		}
+	}
+
+	srcPath, err := c.sourcePath(selectedImage)
+	if err != nil {
+		errorLogger.Printf("%sError fetching today's image: %v", c.logPrefix(), err)
+		return c.useFallback(today)
+	}
+	if !c.install(today, "today", selectedImage, srcPath) {
+		return c.useFallback(today)
+	}
+
+	if c == primary {
+		recordSelection(selectedImage, len(images))
+		if historyFile != "" {
+			if err := recordHistory(mapper, today, selectedImage); err != nil {
+				errorLogger.Printf("Error recording history: %v", err)
+			}
+		}
+	}
+	rotations.Inc()
+
+	logger.Printf("%sToday's image: %s", c.logPrefix(), selectedImage)
+	return true
+}
+
+// useFallback puts the -fallback image in place as today's image of c, if
+// set, after selecting or installing the actual one failed. It returns
+// false, so the scheduler keeps retrying the update.
+func (c *collection) useFallback(today time.Time) bool {
+	if fallbackImage == "" {
+		return false
+	}
+	warnLogger.Printf("%sServing the fallback image %s", c.logPrefix(), fallbackImage)
+	// Named apart from today's image, which may replace it later today
+	c.install(today, "fallback", filepath.Base(fallbackImage), fallbackImage)
+	return false
+}
+
+// install puts the image name at srcPath in place as today's image of c,
+// with its thumbnail and favicon, and removes the previous ones. The asset
+// is named prefix_<period>_<version>, the version being the start of the
+// content hash, so an image replaced mid-period gets a new URL rather than
+// one clients cached. It reports whether that succeeded.
+func (c *collection) install(today time.Time, prefix, name, srcPath string) bool {
+	sum, err := hashFile(srcPath)
+	if err != nil {
+		errorLogger.Printf("%sError opening today's image: %v", c.logPrefix(), err)
+		return false
+	}
+	label := periodLabel(today) + "_" + sum[:8]
+
+	// Copy the image to the asset directory with a unique name. With
+	// -no-copy, the name is only used in URLs and the source is served
+	newImageName := fmt.Sprintf("%s_%s%s", prefix, label, filepath.Ext(name))
+	destPath := srcPath
+	if !noCopy {
+		if err := os.MkdirAll(c.assetDir, 0755); err != nil {
+			errorLogger.Printf("%sError creating asset directory: %v", c.logPrefix(), err)
+			return false
+		}
+		destPath = filepath.Join(c.assetDir, newImageName)
+		if err := copyFile(srcPath, destPath); err != nil {
+			errorLogger.Printf("%sError copying image to asset directory: %v", c.logPrefix(), err)
+			return false
+		}
+	}
+
+	next := &installedImage{
+		filename: newImageName,
+		path:     destPath,
+		source:   name,
+		date:     today.Format("2006-01-02"),
+		time:     today,
+		etag:     `"` + sum + `"`,
+		caption:  readCaption(srcPath, name),
+	}
+	// A missing thumbnail only costs the favicon its small version.
+	// Animated GIFs are only ever served whole, not scaled down. The image
+	// is decoded once for the thumbnail, scaled copies and favicon
+	still := !isVideo(name) && !isAnimatedGIF(destPath)
+	favicon := c == primary && faviconMode == "image" && !isVideo(name)
+	var img image.Image
+	var format string
+	if !noCopy && still || favicon {
+		if img, format, err = decodeImage(destPath); err != nil {
+			errorLogger.Printf("%sError decoding today's image: %v", c.logPrefix(), err)
+		}
+	}
+	if img != nil && thumbSize > 0 && !noCopy && still {
+		if thumb, err := writeThumbnail(img, format, c.assetDir, label); err == nil {
+			next.thumbFilename = thumb
+		} else {
+			errorLogger.Printf("%sError creating thumbnail of today's image: %v", c.logPrefix(), err)
+		}
+	}
+	if img != nil && !noCopy && still {
+		if srcset, err := writeSrcset(img, format, c.assetDir, newImageName); err == nil {
+			next.srcset = srcset
+		} else {
+			errorLogger.Printf("%sError creating scaled copies of today's image: %v", c.logPrefix(), err)
+		}
+	}
+	// Only the primary collection has a favicon; without one, the
+	// thumbnail is served instead
+	if img != nil && favicon {
+		if icon, err := makeFavicon(img); err == nil {
+			next.favicon = icon
+		} else {
+			errorLogger.Printf("Error creating favicon of today's image: %v", err)
+		}
+	}
+
+	// Remove the previous image once the new one is live
+	previous := c.image()
+	c.current.Store(next)
+	if c == primary && statusFile != "" {
+		if err := writeStatus(next); err != nil {
+			errorLogger.Printf("Error writing status file: %v", err)
+		}
+	}
+
+	if previous.filename == "" || noCopy {
+		debugLogger.Printf("%sNo previous image to remove :) ", c.logPrefix())
+	} else if previous.filename != newImageName {
+		if err := os.Remove(filepath.Join(c.assetDir, previous.filename)); err == nil {
+			debugLogger.Printf("%sRemoved previous image: %s", c.logPrefix(), previous.filename)
+		} else {
+			errorLogger.Printf("%sError removing previous image: %v", c.logPrefix(), err)
+		}
+	}
+	if previous.thumbFilename != "" && previous.thumbFilename != next.thumbFilename {
+		if err := os.Remove(filepath.Join(c.assetDir, previous.thumbFilename)); err != nil {
+			errorLogger.Printf("%sError removing previous thumbnail: %v", c.logPrefix(), err)
+		}
+	}
+	for _, scaled := range previous.srcset {
+		if scaled.Filename == previous.filename || next.hasScaled(scaled.Filename) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.assetDir, scaled.Filename)); err != nil {
+			errorLogger.Printf("%sError removing previous scaled copy: %v", c.logPrefix(), err)
+		}
+	}
+	return true
+}
+
+// hasScaled reports whether file is one of the scaled-down copies of img.
+func (img *installedImage) hasScaled(file string) bool {
+	return file != img.filename && slices.ContainsFunc(img.srcset, func(scaled srcsetImage) bool {
+		return scaled.Filename == file
+	})
+}
+
+// isStale reports whether img was selected for a day, or with -interval a
+// period, other than the current one, e.g. because the last update failed.
+func (img *installedImage) isStale() bool {
+	return img.filename != "" && periodLabel(img.time) != periodLabel(time.Now())
+}
+
+// serveCollectionPage serves the page of the collection named in the path.
+func serveCollectionPage(w http.ResponseWriter, r *http.Request) {
+	c, ok := collections[r.PathValue("name")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	img := c.image()
+	data := newPageData(img.filename)
+	if img.filename != "" {
+		data.ImageURL = "/c/" + url.PathEscape(c.name) + "/assets/" + img.filename
+		data.Srcset = srcsetAttr("/c/"+url.PathEscape(c.name)+"/assets/", img.srcset)
+	}
+	data.Date = img.date
+	data.Caption = img.caption
+	data.Lang = requestLang(r)
+	data.Text = catalog[data.Lang]
+	data.Title = data.Text.Title
+	data.Stale = !hideStaleNote && img.isStale()
+	data.CrossFade = false // The script only follows the primary collection
+
+	var page bytes.Buffer
+	if err := renderPage(&page, data); err != nil {
+		errorLogger.Printf("Error rendering page: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Type", "text/html")
+	if data.ImageURL == "" {
+		// The scheduler keeps retrying, and the page reloads as often
+		w.Header().Set("Retry-After", strconv.Itoa(emptyRefreshSeconds))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	page.WriteTo(w)
+}
+
+// serveCollectionToday serves today's image of the collection named in the
+// path at a stable URL.
+func serveCollectionToday(w http.ResponseWriter, r *http.Request) {
+	c, ok := collections[r.PathValue("name")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	img := c.image()
+	if img.filename == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", todayCacheHeader(time.Now()))
+	serveImageFile(w, r, img.path)
+}
+
+// serveCollectionAsset serves today's image or its thumbnail from the asset
+// directory of the collection named in the path.
+func serveCollectionAsset(w http.ResponseWriter, r *http.Request) {
+	c, ok := collections[r.PathValue("name")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	img := c.image()
+	file := r.PathValue("file")
+	if file == "" || (file != img.filename && file != img.thumbFilename && !img.hasScaled(file)) {
+		http.NotFound(w, r)
+		return
+	}
+	if file == img.filename {
+		setDailyCacheHeaders(w, time.Now(), img.etag)
+		serveImageFile(w, r, img.path)
+		return
+	}
+	serveImageFile(w, r, filepath.Join(c.assetDir, file))
+}
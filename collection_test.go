@@ -0,0 +1,198 @@
+package main
+
+import (
+	"image"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollectionUpdateKeepsExtension(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantExt string
+	}{
+		{"png", "monkey.png", ".png"},
+		{"jpeg", "monkey.jpeg", ".jpeg"},
+		{"nested png", "zoo/monkey.png", ".png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			imageDir := t.TempDir()
+			writeTestPNG(t, filepath.Join(imageDir, filepath.FromSlash(tt.source)))
+
+			c := newCollection("test", imageDir, filepath.Join(assetDir, "test"))
+			collections = map[string]*collection{"test": c}
+			defer func() { collections = nil }()
+			if !c.update() {
+				t.Fatal("update() failed")
+			}
+
+			img := c.image()
+			if img.source != tt.source {
+				t.Errorf("source = %q, want %q", img.source, tt.source)
+			}
+			if !strings.HasSuffix(img.filename, tt.wantExt) {
+				t.Errorf("filename = %q, want extension %s", img.filename, tt.wantExt)
+			}
+			if _, err := os.Stat(filepath.Join(c.assetDir, img.filename)); err != nil {
+				t.Errorf("installed image: %v", err)
+			}
+
+			// The page links the installed name, which is served
+			r := httptest.NewRequest("GET", "/c/test/assets/"+img.filename, nil)
+			r.SetPathValue("name", "test")
+			r.SetPathValue("file", img.filename)
+			w := httptest.NewRecorder()
+			serveCollectionAsset(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("serving %s: status %d", img.filename, w.Code)
+			}
+		})
+	}
+}
+
+func TestCollectionUpdateAnimatedGIF(t *testing.T) {
+	tests := []struct {
+		name       string
+		frames     int
+		wantScaled bool
+	}{
+		{"animated", 2, false},
+		{"still", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			defer func(size int, widths []int) { thumbSize, srcsetWidths = size, widths }(thumbSize, srcsetWidths)
+			thumbSize, srcsetWidths = 4, []int{4}
+
+			imageDir := t.TempDir()
+			writeTestGIF(t, filepath.Join(imageDir, "monkey.gif"), tt.frames)
+			c := newCollection("test", imageDir, filepath.Join(assetDir, "test"))
+			if !c.update() {
+				t.Fatal("update() failed")
+			}
+
+			img := c.image()
+			file, err := os.Open(filepath.Join(c.assetDir, img.filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			installed, err := gif.DecodeAll(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(installed.Image) != tt.frames {
+				t.Errorf("installed image has %d frames, want %d", len(installed.Image), tt.frames)
+			}
+			if scaled := img.thumbFilename != "" || len(img.srcset) > 0; scaled != tt.wantScaled {
+				t.Errorf("thumbnail %q and srcset %v, want scaled copies: %v", img.thumbFilename, img.srcset, tt.wantScaled)
+			}
+		})
+	}
+}
+
+// writeTestPNG writes a small PNG to path, creating its directory.
+// TestCollectionUpdateReplacedImage replaces today's image mid-period,
+// which must change its URL, so clients don't keep a cached copy.
+func TestCollectionUpdateReplacedImage(t *testing.T) {
+	setupTest(t)
+	imageDir := t.TempDir()
+	path := filepath.Join(imageDir, "monkey.png")
+	writeTestPNG(t, path)
+	c := newCollection("test", imageDir, filepath.Join(assetDir, "test"))
+	if !c.update() {
+		t.Fatal("update() failed")
+	}
+	before := c.image()
+
+	writeTestFile(t, imageDir, "monkey.png", []byte("\x89PNG not really"))
+	forgetScans()
+	if !c.update() {
+		t.Fatal("update() after replacing failed")
+	}
+	after := c.image()
+	if after.source != before.source {
+		t.Fatalf("selected %q, then %q", before.source, after.source)
+	}
+	if after.filename == before.filename || after.etag == before.etag {
+		t.Errorf("replaced image installed as %s %s, like before", after.filename, after.etag)
+	}
+	if _, err := os.Stat(filepath.Join(c.assetDir, before.filename)); !os.IsNotExist(err) {
+		t.Errorf("previous image %s not removed: %v", before.filename, err)
+	}
+}
+
+func writeTestPNG(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, image.NewGray(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCollectionConcurrentReads renews today's image while the handlers
+// read it. Run with -race.
+func TestCollectionConcurrentReads(t *testing.T) {
+	setupTest(t)
+	defer func(c *collection, dir string) { primary, imageDir = c, dir }(primary, imageDir)
+	imageDir = t.TempDir()
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		writeTestPNG(t, filepath.Join(imageDir, name))
+	}
+	primary = newCollection("", imageDir, assetDir)
+	if !primary.update() {
+		t.Fatal("update() failed")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			forgetScans()
+			primary.update()
+		}
+	}()
+
+	handlers := map[string]http.HandlerFunc{
+		"/":            servePage,
+		"/today.json":  serveTodayInfo,
+		"/favicon.ico": serveFavicon,
+		"/today":       serveToday,
+	}
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		for path, handler := range handlers {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest("GET", path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s: status %d", path, w.Code)
+			}
+		}
+		w := httptest.NewRecorder()
+		cacheAssets(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/"+primary.image().filename, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET today's asset: status %d", w.Code)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// configValues holds the settings of the -config file by normalized key.
+	// The getEnv functions fall back to them when the environment variable
+	// isn't set, so the command line overrides the environment, which
+	// overrides the file.
+	configValues map[string]string
+	// configNames maps the normalized keys to how the file spells them.
+	configNames map[string]string
+	// envKeys records the normalized keys looked up by the getEnv
+	// functions, which are the keys a config file may set.
+	envKeys = make(map[string]bool)
+)
+
+// lookupEnv returns the value of the environment variable key, or else the
+// value the config file sets for it.
+func lookupEnv(key string) (string, bool) {
+	normalized := normalizeConfigKey(key)
+	envKeys[normalized] = true
+	if value, exists := os.LookupEnv(key); exists {
+		return value, true
+	}
+	value, exists := configValues[normalized]
+	return value, exists
+}
+
+// normalizeConfigKey makes the names of environment variables and config
+// keys comparable: IMAGE_DIR, image_dir, image-dir and imageDir all match.
+func normalizeConfigKey(key string) string {
+	key = strings.ToLower(key)
+	key = strings.ReplaceAll(key, "_", "")
+	return strings.ReplaceAll(key, "-", "")
+}
+
+// loadConfig loads the config file named by -config in args, or else by
+// CONFIG_FILE, before the flags are defined. It must be known that early
+// because the file provides the defaults of the flags.
+func loadConfig(args []string) {
+	path := os.Getenv("CONFIG_FILE")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		path = value
+	}
+	if path == "" {
+		return
+	}
+
+	values, err := readConfigFile(path)
+	if err != nil {
+		log.Fatalf("Error loading config file: %v", err)
+	}
+	configValues = make(map[string]string, len(values))
+	configNames = make(map[string]string, len(values))
+	for key, value := range values {
+		normalized := normalizeConfigKey(key)
+		if other, dup := configNames[normalized]; dup {
+			log.Fatalf("Error loading config file: %s and %s set the same setting", other, key)
+		}
+		configValues[normalized] = value
+		configNames[normalized] = key
+	}
+}
+
+// warnUnknownConfigKeys logs the keys of the config file that no setting
+// was looked up by, such as misspelled ones.
+func warnUnknownConfigKeys() {
+	var unknown []string
+	for normalized, key := range configNames {
+		if !envKeys[normalized] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		log.Printf("Ignoring unknown config key %q", key)
+	}
+}
+
+// readConfigFile reads the settings of a flat YAML or TOML file, picking
+// the syntax by extension: YAML for .yaml and .yml files, TOML otherwise.
+// Values must be scalars, which are taken as written; nested values, lists
+// and TOML tables aren't supported.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		return readYAMLConfig(path, data)
+	}
+	return readTOMLConfig(path, data)
+}
+
+// readYAMLConfig reads the settings of the YAML document data, read from
+// path. Scalars keep their text, so e.g. 0755 isn't turned into 493.
+func readYAMLConfig(path string, data []byte) (map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	values := make(map[string]string)
+	if len(doc.Content) == 0 {
+		return values, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected key: value pairs", path, root.Line)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s:%d: %s isn't a single value, nested values aren't supported", path, value.Line, key.Value)
+		}
+		if _, dup := values[key.Value]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate key %q", path, key.Line, key.Value)
+		}
+		if value.Tag == "!!null" {
+			values[key.Value] = ""
+		} else {
+			values[key.Value] = value.Value
+		}
+	}
+	return values, nil
+}
+
+// readTOMLConfig reads the settings of the TOML document data, read from
+// path.
+func readTOMLConfig(path string, data []byte) (map[string]string, error) {
+	var doc map[string]any
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	values := make(map[string]string, len(doc))
+	for _, key := range slices.Sorted(maps.Keys(doc)) {
+		switch value := doc[key].(type) {
+		case string:
+			values[key] = value
+		case int64:
+			values[key] = strconv.FormatInt(value, 10)
+		case float64:
+			values[key] = strconv.FormatFloat(value, 'g', -1, 64)
+		case bool:
+			values[key] = strconv.FormatBool(value)
+		case map[string]any, []any, []map[string]any:
+			return nil, fmt.Errorf("%s: %s isn't a single value, nested values aren't supported", path, key)
+		default:
+			return nil, fmt.Errorf("%s: %s: unsupported %T value", path, key, value)
+		}
+	}
+	return values, nil
+}
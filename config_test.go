@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "yaml scalars",
+			file:    "motd.yaml",
+			content: "image_dir: /srv/images\nport: 8080\nheadless: true\nratio: 1.5\nmode: 0755\ninterval: 1h30m\n",
+			want:    map[string]string{"image_dir": "/srv/images", "port": "8080", "headless": "true", "ratio": "1.5", "mode": "0755", "interval": "1h30m"},
+		},
+		{
+			name:    "yaml quoting",
+			file:    "motd.yml",
+			content: "title: \"a # b\"\nsingle: 'it''s'\nescaped: \"tab\\there\"\nempty: \"\"\n",
+			want:    map[string]string{"title": "a # b", "single": "it's", "escaped": "tab\there", "empty": ""},
+		},
+		{
+			name:    "yaml comments",
+			file:    "motd.yaml",
+			content: "---\n# The port\nport: 8080 # trailing\n\nregion: eu#not a comment\n",
+			want:    map[string]string{"port": "8080", "region": "eu#not a comment"},
+		},
+		{
+			name:    "yaml empty value",
+			file:    "motd.yaml",
+			content: "fallback:\nnull_value: ~\n",
+			want:    map[string]string{"fallback": "", "null_value": ""},
+		},
+		{
+			name:    "yaml empty file",
+			file:    "motd.yaml",
+			content: "# Nothing set\n",
+			want:    map[string]string{},
+		},
+		{name: "yaml nested", file: "motd.yaml", content: "pools:\n  a: b\n", wantErr: true},
+		{name: "yaml list", file: "motd.yaml", content: "pools: [a, b]\n", wantErr: true},
+		{name: "yaml duplicate", file: "motd.yaml", content: "port: 1\nport: 2\n", wantErr: true},
+		{name: "yaml not a mapping", file: "motd.yaml", content: "- port\n", wantErr: true},
+		{name: "yaml syntax", file: "motd.yaml", content: "port: \"8080\n", wantErr: true},
+		{
+			name:    "toml scalars",
+			file:    "motd.toml",
+			content: "image_dir = \"/srv/images\"\nport = 8080\nheadless = true\nratio = 1.5\ninterval = \"1h30m\"\n",
+			want:    map[string]string{"image_dir": "/srv/images", "port": "8080", "headless": "true", "ratio": "1.5", "interval": "1h30m"},
+		},
+		{
+			name:    "toml quoting",
+			file:    "motd.toml",
+			content: "title = \"a # b\"\nliteral = 'C:\\images'\nescaped = \"tab\\there\"\n",
+			want:    map[string]string{"title": "a # b", "literal": `C:\images`, "escaped": "tab\there"},
+		},
+		{
+			name:    "toml comments",
+			file:    "motd.toml",
+			content: "# The port\nport = 8080 # trailing\n",
+			want:    map[string]string{"port": "8080"},
+		},
+		{
+			name:    "other extension is toml",
+			file:    "motd.conf",
+			content: "port = 8080\n",
+			want:    map[string]string{"port": "8080"},
+		},
+		{name: "toml table", file: "motd.toml", content: "[server]\nport = 8080\n", wantErr: true},
+		{name: "toml array", file: "motd.toml", content: "pools = [\"a\", \"b\"]\n", wantErr: true},
+		{name: "toml duplicate", file: "motd.toml", content: "port = 1\nport = 2\n", wantErr: true},
+		{name: "toml date", file: "motd.toml", content: "start = 2024-01-01\n", wantErr: true},
+		{name: "toml unquoted string", file: "motd.toml", content: "image_dir = /srv/images\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), tt.file, []byte(tt.content))
+			got, err := readConfigFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readConfigFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("readConfigFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	defer func(values, names map[string]string, keys map[string]bool) {
+		configValues, configNames, envKeys = values, names, keys
+	}(configValues, configNames, envKeys)
+	envKeys = make(map[string]bool)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	for _, file := range []struct{ name, content string }{
+		{"motd.yaml", "motd_test_dir: /from/file\nmotd_test_port: 1\nmotd-test-headless: true\nmotd_test_count: lots\nmotd_test_typo: x\n"},
+		{"motd.toml", "motd_test_dir = \"/from/file\"\nmotd_test_port = 1\nMOTD_TEST_HEADLESS = true\nmotd_test_count = \"lots\"\nmotd_test_typo = \"x\"\n"},
+	} {
+		t.Run(file.name, func(t *testing.T) {
+			logged.Reset()
+			path := writeTestFile(t, t.TempDir(), file.name, []byte(file.content))
+			t.Setenv("MOTD_TEST_PORT", "2")
+			args := []string{"-config", path, "-motd-test-headless=false"}
+			loadConfig(args)
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.String("config", "", "")
+			dir := fs.String("motd-test-dir", getEnv("MOTD_TEST_DIR", "/default"), "")
+			port := fs.Int("motd-test-port", getEnvInt("MOTD_TEST_PORT", 0), "")
+			headless := fs.Bool("motd-test-headless", getEnvBool("MOTD_TEST_HEADLESS", false), "")
+			count := fs.Int("motd-test-count", getEnvInt("MOTD_TEST_COUNT", 7), "")
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			warnUnknownConfigKeys()
+
+			// The file overrides the defaults, the environment the file, and
+			// the command line everything
+			if *dir != "/from/file" {
+				t.Errorf("dir = %q, want the file's", *dir)
+			}
+			if *port != 2 {
+				t.Errorf("port = %d, want the environment's", *port)
+			}
+			if *headless {
+				t.Error("headless = true, want the command line's false")
+			}
+			// Values of the wrong type are ignored like in the environment
+			if *count != 7 {
+				t.Errorf("count = %d, want the default", *count)
+			}
+			if !strings.Contains(logged.String(), `Ignoring invalid MOTD_TEST_COUNT="lots"`) {
+				t.Errorf("invalid value not logged: %q", logged.String())
+			}
+			if !strings.Contains(logged.String(), `Ignoring unknown config key "motd_test_typo"`) {
+				t.Errorf("unknown key not logged: %q", logged.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// encoders holds the formats images can be converted to.
+var encoders = map[string]func(io.Writer, image.Image) error{
+	"jpeg": func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, &jpeg.Options{Quality: 90}) },
+	"png":  png.Encode,
+	"gif":  func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) },
+}
+
+// encodeSlots caps the number of conversions running at once.
+var encodeSlots = make(chan struct{}, runtime.NumCPU())
+
+// maxVariantSize caps the requested width and height of variants.
+const maxVariantSize = 4096
+
+// variantKey identifies a variant of a specific version of a file.
+type variantKey struct {
+	path          string
+	modTime       time.Time
+	width, height int
+	format        string
+}
+
+var (
+	variantCache        *lruCache[variantKey, []byte] // Set up from -variant-cache-size
+	variantCacheHits    = promauto.NewCounter(prometheus.CounterOpts{Name: "motd_variant_cache_hits_total", Help: "Resized or converted images served from the cache."})
+	variantCacheMisses  = promauto.NewCounter(prometheus.CounterOpts{Name: "motd_variant_cache_misses_total", Help: "Resized or converted images that had to be encoded."})
+	variantCacheEvicted = promauto.NewCounter(prometheus.CounterOpts{Name: "motd_variant_cache_evictions_total", Help: "Images evicted from the variant cache."})
+	variantCacheBytes   = promauto.NewGauge(prometheus.GaugeOpts{Name: "motd_variant_cache_bytes", Help: "Size of the variant cache."})
+)
+
+// newVariantCache returns a cache of encoded variants holding up to capacity
+// bytes.
+func newVariantCache(capacity int64) *lruCache[variantKey, []byte] {
+	size := func(data []byte) int64 { return int64(len(data)) }
+	return newLRUCache(capacity, size, func(variantKey, []byte) { variantCacheEvicted.Inc() })
+}
+
+// serveVariant serves the image at path scaled down to fit width x height
+// (0 leaves a dimension unbounded) and encoded as format (empty keeps the
+// source format). The original is served if no change is needed, or if it's
+// an animated GIF to be served as a GIF.
+func serveVariant(w http.ResponseWriter, r *http.Request, path string, width, height int, format string) {
+	if width < 0 || height < 0 || width > maxVariantSize || height > maxVariantSize {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+		return
+	}
+	format = strings.ToLower(format)
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if isVideo(path) {
+		http.Error(w, "videos can't be converted", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	_, sourceFormat, err := checkImageSize(path)
+	if err != nil {
+		errorLogger.Printf("Error reading %s: %v", path, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if format == "" {
+		format = sourceFormat
+	}
+	encode, ok := encoders[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported format %q", format), http.StatusUnsupportedMediaType)
+		return
+	}
+	// Animated GIFs are passed through rather than cut down to one frame,
+	// unless another format is asked for
+	if (width == 0 && height == 0 || isAnimatedGIF(path)) && format == sourceFormat {
+		serveImageFile(w, r, path)
+		return
+	}
+
+	key := variantKey{path: path, modTime: info.ModTime(), width: width, height: height, format: format}
+	data, ok := variantCache.Get(key)
+	if ok {
+		variantCacheHits.Inc()
+	} else {
+		variantCacheMisses.Inc()
+		select {
+		case encodeSlots <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		data, err = encodeVariant(path, width, height, encode)
+		<-encodeSlots
+		if err != nil {
+			errorLogger.Printf("Error converting %s to %s: %v", path, format, err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		variantCache.Add(key, data)
+		variantCacheBytes.Set(float64(variantCache.Size()))
+	}
+
+	w.Header().Set("Content-Type", "image/"+format)
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// encodeVariant decodes the image at path, scales it down to fit width x
+// height if either is set, and re-encodes it with encode.
+func encodeVariant(path string, width, height int, encode func(io.Writer, image.Image) error) ([]byte, error) {
+	img, _, err := decodeImage(path)
+	if err != nil {
+		return nil, err
+	}
+	if width > 0 || height > 0 {
+		img = scaleToFit(img, width, height)
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
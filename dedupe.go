@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentHash is the sha256 of a file as of its size and modification time.
+type contentHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+var (
+	contentHashMutex sync.Mutex
+	contentHashes    = make(map[string]contentHash) // By path
+)
+
+// cachedHash returns the hex sha256 of the file at path, hashing it again
+// only if its size or modification time changed since the last call.
+func cachedHash(path string, size int64, modTime time.Time) (string, error) {
+	contentHashMutex.Lock()
+	cached, ok := contentHashes[path]
+	contentHashMutex.Unlock()
+	if ok && cached.size == size && cached.modTime.Equal(modTime) {
+		return cached.sum, nil
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	contentHashMutex.Lock()
+	contentHashes[path] = contentHash{size: size, modTime: modTime, sum: sum}
+	contentHashMutex.Unlock()
+	return sum, nil
+}
+
+// findDuplicates returns the groups of byte-identical images among images
+// in dir, each sorted by filename. Only images sharing their size with
+// another one are hashed.
+func findDuplicates(dir string, images []imageInfo) [][]string {
+	bySize := make(map[int64][]imageInfo)
+	for _, image := range images {
+		bySize[image.Size] = append(bySize[image.Size], image)
+	}
+
+	var groups [][]string
+	for _, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		bySum := make(map[string][]string)
+		for _, image := range candidates {
+			sum, err := cachedHash(filepath.Join(dir, filepath.FromSlash(image.Filename)), image.Size, image.ModTime)
+			if err != nil {
+				warnLogger.Printf("Error hashing %s for duplicates: %v", image.Filename, err)
+				continue
+			}
+			bySum[sum] = append(bySum[sum], image.Filename)
+		}
+		for _, names := range bySum {
+			if len(names) > 1 {
+				slices.Sort(names)
+				groups = append(groups, names)
+			}
+		}
+	}
+	slices.SortFunc(groups, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+	return groups
+}
+
+// dedupeImages logs the byte-identical images among images in dir, so they
+// don't silently get picked more often. With -dedupe collapse, only the
+// first name of each group stays a candidate.
+func dedupeImages(dir string, images []imageInfo) []imageInfo {
+	groups := findDuplicates(dir, images)
+	drop := make(map[string]bool)
+	for _, names := range groups {
+		if dedupe == "collapse" {
+			warnLogger.Printf("Duplicate images, keeping %s: %s", names[0], strings.Join(names, ", "))
+			for _, name := range names[1:] {
+				drop[name] = true
+			}
+		} else {
+			warnLogger.Printf("Duplicate images: %s", strings.Join(names, ", "))
+		}
+	}
+	if len(drop) == 0 {
+		return images
+	}
+	return slices.DeleteFunc(images, func(image imageInfo) bool { return drop[image.Filename] })
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runDiff prints the dates in a range whose selection differs between the
+// pools in two image directories, e.g. before adding or removing images.
+// Each directory is loaded as the image directory would be, with its pools,
+// weights.txt, schedule.txt and the playlist.
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	registerFlags(fs)
+	var from, to string
+	fs.StringVar(&from, "from", "", "First date to compare, e.g. 2024-01-01 (default today)")
+	fs.StringVar(&to, "to", "", "Last date to compare (default 30 days after -from)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [flags] olddir newdir\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	closeLog := setup()
+	defer closeLog()
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if remoteImages() {
+		errorLogger.Fatalf("diff compares image directories, so it can't be used with -index-url or -urls")
+	}
+	start, end, err := parseDateRange(from, to, 30)
+	if err != nil {
+		errorLogger.Fatalf("%v", err)
+	}
+
+	var mappers [2]*ImageMapper
+	for i, dir := range fs.Args() {
+		imageDir = dir
+		images, err := loadImages()
+		if err != nil {
+			errorLogger.Fatalf("Error getting image list of %s: %v", dir, err)
+		}
+		if len(images) == 0 {
+			errorLogger.Fatalf("No images available in %s", dir)
+		}
+		mappers[i] = newMapper(images)
+	}
+
+	total, changed := 0, 0
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		total++
+		oldImage, err := previewImage(mappers[0], date, region)
+		if err != nil {
+			errorLogger.Fatalf("Error selecting image for %s: %v", date.Format("2006-01-02"), err)
+		}
+		newImage, err := previewImage(mappers[1], date, region)
+		if err != nil {
+			errorLogger.Fatalf("Error selecting image for %s: %v", date.Format("2006-01-02"), err)
+		}
+		if oldImage != newImage {
+			changed++
+			fmt.Printf("%s: %s -> %s\n", date.Format("2006-01-02"), oldImage, newImage)
+		}
+	}
+	logger.Printf("%d of %d dates change", changed, total)
+}
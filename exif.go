@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// EXIF tags read by exifDate.
+const (
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// errNoExif is returned by exifDate for files without a usable date.
+var errNoExif = errors.New("no EXIF date")
+
+// exifDate returns the date a JPEG was taken, from the DateTimeOriginal tag
+// of its EXIF data. Only the JPEG segments before the image data are read.
+func exifDate(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return time.Time{}, errNoExif
+	}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil || header[0] != 0xff {
+			return time.Time{}, errNoExif
+		}
+		marker := header[1]
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		// Start of scan and end of image: no metadata follows
+		if marker == 0xda || marker == 0xd9 || length < 2 {
+			return time.Time{}, errNoExif
+		}
+		if marker != 0xe1 {
+			if _, err := r.Discard(length - 2); err != nil {
+				return time.Time{}, errNoExif
+			}
+			continue
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return time.Time{}, errNoExif
+		}
+		if tiff, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); ok {
+			return tiffDate(tiff)
+		}
+	}
+}
+
+// tiffDate finds DateTimeOriginal in the TIFF structure of an EXIF segment:
+// IFD0 points to the EXIF IFD, which holds the date as
+// "2006:01:02 15:04:05" in local time.
+func tiffDate(tiff []byte) (time.Time, error) {
+	if len(tiff) < 8 {
+		return time.Time{}, errNoExif
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errNoExif
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return time.Time{}, errNoExif
+	}
+
+	exifIFD, ok := ifdEntry(tiff, order, order.Uint32(tiff[4:]), tagExifIFD)
+	if !ok {
+		return time.Time{}, errNoExif
+	}
+	entry, ok := ifdEntry(tiff, order, order.Uint32(exifIFD[8:]), tagDateTimeOriginal)
+	if !ok {
+		return time.Time{}, errNoExif
+	}
+	count, offset := order.Uint32(entry[4:]), order.Uint32(entry[8:])
+	if count < 19 || uint64(offset)+19 > uint64(len(tiff)) {
+		return time.Time{}, errNoExif
+	}
+	taken, err := time.ParseInLocation("2006:01:02 15:04:05", string(tiff[offset:offset+19]), location)
+	if err != nil {
+		return time.Time{}, errNoExif
+	}
+	return taken, nil
+}
+
+// ifdEntry returns the 12 byte entry of tag in the IFD at offset: tag,
+// type, count and value or offset of the value.
+func ifdEntry(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) ([]byte, bool) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, false
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	entries := tiff[offset+2:]
+	for i := 0; i < n && (i+1)*12 <= len(entries); i++ {
+		entry := entries[i*12 : (i+1)*12]
+		if order.Uint16(entry) == tag {
+			return entry, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// testTIFF returns the TIFF structure of an EXIF segment in order, with
+// IFD0 pointing to an EXIF IFD holding date as DateTimeOriginal.
+func testTIFF(order binary.ByteOrder, date string) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+	// IFD0 at 8, the EXIF IFD at 26 and the date at 44
+	for _, entry := range [][4]uint32{{tagExifIFD, 4, 1, 26}, {tagDateTimeOriginal, 2, uint32(len(date) + 1), 44}} {
+		binary.Write(&buf, order, uint16(1))
+		binary.Write(&buf, order, uint16(entry[0]))
+		binary.Write(&buf, order, uint16(entry[1]))
+		binary.Write(&buf, order, entry[2])
+		binary.Write(&buf, order, entry[3])
+		binary.Write(&buf, order, uint32(0)) // No next IFD
+	}
+	buf.WriteString(date + "\x00")
+	return buf.Bytes()
+}
+
+// testJPEG returns the start of a JPEG with the given segments, each a
+// marker followed by its payload, up to the start of scan.
+func testJPEG(segments ...[]byte) []byte {
+	data := []byte{0xff, 0xd8}
+	for _, segment := range segments {
+		data = append(data, 0xff, segment[0])
+		data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+1))
+		data = append(data, segment[1:]...)
+	}
+	return append(data, 0xff, 0xda, 0x00, 0x02)
+}
+
+func TestExifDate(t *testing.T) {
+	setupTest(t)
+	want := time.Date(2021, 7, 14, 9, 30, 0, 0, time.UTC)
+	jfif := append([]byte{0xe0}, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"...)
+	xmp := append([]byte{0xe1}, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"...)
+	exif := func(tiff []byte) []byte {
+		return append([]byte{0xe1}, append([]byte("Exif\x00\x00"), tiff...)...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"little endian", testJPEG(jfif, exif(testTIFF(binary.LittleEndian, "2021:07:14 09:30:00"))), true},
+		{"big endian", testJPEG(jfif, exif(testTIFF(binary.BigEndian, "2021:07:14 09:30:00"))), true},
+		{"after xmp", testJPEG(xmp, exif(testTIFF(binary.BigEndian, "2021:07:14 09:30:00"))), true},
+		{"no exif", testJPEG(jfif), false},
+		{"invalid date", testJPEG(exif(testTIFF(binary.LittleEndian, "2021:13:45 09:30:00"))), false},
+		{"short date", testJPEG(exif(testTIFF(binary.LittleEndian, "2021:07:14"))), false},
+		{"not a jpeg", testTIFF(binary.LittleEndian, "2021:07:14 09:30:00"), false},
+		{"segment too short", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01}, false},
+		{"empty", nil, false},
+	}
+	full := testJPEG(exif(testTIFF(binary.LittleEndian, "2021:07:14 09:30:00")))
+	for n := 0; n < len(full)-4; n++ {
+		tests = append(tests, struct {
+			name string
+			data []byte
+			ok   bool
+		}{"truncated", full[:n], false})
+	}
+	for _, tt := range tests {
+		path := writeTestFile(t, t.TempDir(), "photo.jpg", tt.data)
+		taken, err := exifDate(path)
+		if tt.ok && (err != nil || !taken.Equal(want)) {
+			t.Errorf("%s: exifDate() = %v, %v, want %v", tt.name, taken, err, want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s (%d bytes): exifDate() = %v, want an error", tt.name, len(tt.data), taken)
+		}
+	}
+}
+
+func TestTiffDateMalformed(t *testing.T) {
+	setupTest(t)
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		valid := testTIFF(order, "2021:07:14 09:30:00")
+		tests := []struct {
+			name   string
+			offset int // Where value is written
+			value  uint32
+			size   int // Of value, 2 or 4 bytes
+			ok     bool
+		}{
+			{"byte order", 0, 0x5858, 2, false},
+			{"magic", 2, 43, 2, false},
+			{"IFD0 past the end", 4, 0xfffffff0, 4, false},
+			{"IFD0 at the end", 4, uint32(len(valid)), 4, false},
+			{"IFD0 without entries", 8, 0, 2, false},
+			{"EXIF IFD past the end", 18, 0xffffffff, 4, false},
+			{"EXIF IFD at the last byte", 18, uint32(len(valid) - 1), 4, false},
+			// The entries that exist are still read
+			{"EXIF IFD with too many entries", 26, 0xffff, 2, true},
+			{"date past the end", 36, 0xffffffff, 4, false},
+			{"date overlapping the end", 36, uint32(len(valid) - 10), 4, false},
+			{"date count too small", 32, 10, 4, false},
+		}
+		for _, tt := range tests {
+			tiff := bytes.Clone(valid)
+			if tt.size == 2 {
+				order.PutUint16(tiff[tt.offset:], uint16(tt.value))
+			} else {
+				order.PutUint32(tiff[tt.offset:], tt.value)
+			}
+			if taken, err := tiffDate(tiff); (err == nil) != tt.ok {
+				t.Errorf("%s, %v: tiffDate() = %v, %v, want ok %v", tt.name, order, taken, err, tt.ok)
+			}
+		}
+		// Cut off anywhere before the end of the date
+		for n := range len(valid) - 1 {
+			if taken, err := tiffDate(valid[:n]); err == nil {
+				t.Errorf("truncated to %d bytes, %v: tiffDate() = %v, want an error", n, order, taken)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serveExport streams every image in the current pool as a zip archive.
+// Images are stored uncompressed, since they're compressed already.
+func serveExport(w http.ResponseWriter, r *http.Request) {
+	infos := primary.infos.Load()
+	if infos == nil || len(*infos) == 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no images available"})
+		return
+	}
+
+	filename := fmt.Sprintf("motd-images-%s.zip", time.Now().In(location).Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	archive := zip.NewWriter(w)
+	for _, info := range *infos {
+		if err := addToExport(archive, info); err != nil {
+			// The response has started, so all that's left is to cut it short
+			errorLogger.Printf("Error exporting %s: %v", info.Filename, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		errorLogger.Printf("Error finishing export: %v", err)
+	}
+}
+
+// addToExport writes the pool image described by info to archive.
+func addToExport(archive *zip.Writer, info imageInfo) error {
+	path, err := sourcePath(info.Filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     info.Filename,
+		Method:   zip.Store,
+		Modified: stat.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func serveFavicon(w http.ResponseWriter, r *http.Request) {
+	switch faviconMode {
+	case "off":
+		w.WriteHeader(http.StatusNoContent)
+	case "icon":
+		if faviconFile != "" {
+			serveImageFile(w, r, faviconFile)
+			return
+		}
+		http.ServeContent(w, r, "favicon.png", startTime, bytes.NewReader(generatedIcon()))
+	default:
+		img := primary.image()
+		if img.filename == "" || isVideo(img.filename) {
+			http.NotFound(w, r)
+			return
+		}
+		setDailyCacheHeaders(w, time.Now(), img.etag)
+		if img.favicon != nil {
+			w.Header().Set("Content-Type", "image/x-icon")
+			http.ServeContent(w, r, "favicon.ico", img.time, bytes.NewReader(img.favicon))
+			return
+		}
+		if img.thumbFilename != "" {
+			serveImageFile(w, r, filepath.Join(primary.assetDir, img.thumbFilename))
+			return
+		}
+		serveImageFile(w, r, img.path)
+	}
+}
+
+// faviconSize is the width and height of the favicon made from today's
+// image.
+const faviconSize = 32
+
+// makeFavicon returns an ICO of img, cropped to a centered square and
+// scaled down to faviconSize, for browsers to use as tab icon.
+func makeFavicon(img image.Image) ([]byte, error) {
+	var icon bytes.Buffer
+	if err := png.Encode(&icon, scaleToFit(cropSquare(img), faviconSize, faviconSize)); err != nil {
+		return nil, err
+	}
+	return encodeICO(icon.Bytes(), faviconSize), nil
+}
+
+// encodeICO wraps a size x size PNG in an ICO file with a single entry.
+// Browsers and Windows since Vista accept PNG data in ICO entries.
+func encodeICO(pngData []byte, size int) []byte {
+	const headerSize, entrySize = 6, 16
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, struct {
+		Reserved, Type, Count uint16
+	}{0, 1, 1})
+	binary.Write(&buf, binary.LittleEndian, struct {
+		Width, Height, Colors, Reserved uint8
+		Planes, BitCount                uint16
+		Size, Offset                    uint32
+	}{
+		Width:    uint8(size % 256), // 0 means 256
+		Height:   uint8(size % 256),
+		Planes:   1,
+		BitCount: 32,
+		Size:     uint32(len(pngData)),
+		Offset:   headerSize + entrySize,
+	})
+	buf.Write(pngData)
+	return buf.Bytes()
+}
+
+// startTime is used as modification time for generated content.
+var startTime = time.Now()
+
+// generatedIcon returns a PNG of a plain disc used when no favicon file is
+// configured.
+var generatedIcon = sync.OnceValue(func() []byte {
+	const size = 32
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	fill := color.NRGBA{R: 0x8b, G: 0x5a, B: 0x2b, A: 0xff}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := x-size/2, y-size/2
+			if dx*dx+dy*dy < (size/2)*(size/2) {
+				img.SetNRGBA(x, y, fill)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		errorLogger.Printf("Error generating favicon: %v", err)
+	}
+	return buf.Bytes()
+})
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// featuredImage overrides the daily pick while set. It is guarded by
+// primary.mutex and persisted to featuredFile.
+var featuredImage string
+
+// loadFeatured restores the featured image saved by a previous run.
+func loadFeatured() {
+	if featuredFile == "" {
+		return
+	}
+	data, err := os.ReadFile(featuredFile)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			errorLogger.Printf("Error reading featured image file: %v", err)
+		}
+		return
+	}
+	featuredImage = strings.TrimSpace(string(data))
+	if featuredImage != "" {
+		logger.Printf("Featured image restored: %s", featuredImage)
+	}
+}
+
+// saveFeatured persists featuredImage, removing the file once cleared.
+func saveFeatured() error {
+	if featuredFile == "" {
+		return nil
+	}
+	if featuredImage == "" {
+		err := os.Remove(featuredFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(featuredFile, []byte(featuredImage+"\n"), 0644)
+}
+
+// serveFeature features the image given by the file query parameter until
+// it is cleared, and applies it right away.
+func serveFeature(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	file := r.URL.Query().Get("file")
+	mapper := primary.mapper.Load()
+	if file == "" || mapper == nil || !mapper.Contains(file) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "image not in pool"})
+		return
+	}
+
+	if err := setFeatured(file); err != nil {
+		errorLogger.Printf("Error saving featured image: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	logger.Printf("Featured image set: %s", file)
+
+	if !primary.update() {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "featured image saved, but updating today's image failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"featured": file})
+}
+
+// serveClearFeature clears the featured image and resumes normal rotation.
+func serveClearFeature(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	if err := setFeatured(""); err != nil {
+		errorLogger.Printf("Error clearing featured image: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	logger.Println("Featured image cleared")
+
+	if !primary.update() {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "featured image cleared, but updating today's image failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"featured": ""})
+}
+
+// setFeatured updates and persists featuredImage under primary.mutex.
+func setFeatured(file string) error {
+	if !primary.lock("setting the featured image") {
+		return errors.New("an image update is still in progress")
+	}
+	defer primary.unlock()
+
+	featuredImage = file
+	return saveFeatured()
+}
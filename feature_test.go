@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeFeature(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		file       string
+		failUpdate bool // Whether copying today's image fails
+		wantCode   int
+		wantError  string
+	}{
+		{"feature", serveFeature, "b.png", false, http.StatusOK, ""},
+		{"feature not in pool", serveFeature, "missing.png", false, http.StatusNotFound, "image not in pool"},
+		{"feature failed update", serveFeature, "b.png", true, http.StatusInternalServerError, "featured image saved, but updating today's image failed"},
+		{"clear", serveClearFeature, "", false, http.StatusOK, ""},
+		{"clear failed update", serveClearFeature, "", true, http.StatusInternalServerError, "featured image cleared, but updating today's image failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			defer func(c *collection, dir, file, featured string) {
+				primary, imageDir, featuredFile, featuredImage = c, dir, file, featured
+			}(primary, imageDir, featuredFile, featuredImage)
+			imageDir = t.TempDir()
+			featuredFile = ""
+			for _, name := range []string{"a.png", "b.png"} {
+				writeTestPNG(t, filepath.Join(imageDir, name))
+			}
+			primary = newCollection("", imageDir, assetDir)
+			if !primary.update() {
+				t.Fatal("update() failed")
+			}
+			if tt.failUpdate {
+				// The asset directory can't be created below a file
+				primary.assetDir = filepath.Join(writeTestFile(t, t.TempDir(), "file", nil), "assets")
+			}
+
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(http.MethodPost, "/admin/feature?file="+tt.file, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"] != tt.wantError {
+				t.Errorf("error = %q, want %q", body["error"], tt.wantError)
+			}
+			if tt.wantCode == http.StatusOK && primary.image().source != tt.file && tt.file != "" {
+				t.Errorf("today's image is %s, want %s", primary.image().source, tt.file)
+			}
+		})
+	}
+}
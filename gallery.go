@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	galleryPageSize  = 100
+	galleryThumbSize = 200
+)
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`
+<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="UTF-8">
+    <title>{{.Text.GalleryHeading}}</title>
+    <style>
+        body {
+            background-color: #121212;
+            color: #ffffff;
+            font-family: Arial, sans-serif;
+            text-align: center;
+            margin: 0;
+            padding: 0 20px 20px;
+        }
+        h1 {
+            margin-top: 20px;
+        }
+        .grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fill, minmax({{.ThumbSize}}px, 1fr));
+            gap: 12px;
+        }
+        .grid img, .grid video {
+            width: 100%;
+            height: {{.ThumbSize}}px;
+            object-fit: cover;
+            border-radius: 8px;
+        }
+        nav {
+            margin-top: 20px;
+        }
+        nav a {
+            color: #bbbbbb;
+            margin: 0 10px;
+        }
+    </style>
+</head>
+<body>
+    <h1>{{.Text.GalleryHeading}}</h1>
+    <div class="grid">
+{{- range .Images}}
+        <a href="{{.URL}}" title="{{.Name}}">
+{{- if .IsVideo}}
+            <video src="{{.URL}}" muted preload="metadata"></video>
+{{- else}}
+            <img src="{{.ThumbURL}}" alt="{{.Name}}" loading="lazy">
+{{- end}}
+        </a>
+{{- end}}
+    </div>
+{{- if or .PrevURL .NextURL}}
+    <nav>
+{{- if .PrevURL}}
+        <a href="{{.PrevURL}}">{{.Text.PreviousPage}}</a>
+{{- end}}
+{{- if .NextURL}}
+        <a href="{{.NextURL}}">{{.Text.NextPage}}</a>
+{{- end}}
+    </nav>
+{{- end}}
+</body>
+</html>
+`))
+
+// galleryImage is an image linked from the gallery.
+type galleryImage struct {
+	Name     string
+	URL      string
+	ThumbURL string
+	IsVideo  bool
+}
+
+// galleryData is passed to galleryTemplate.
+type galleryData struct {
+	Lang      string
+	Text      messages
+	ThumbSize int
+	Images    []galleryImage
+	PrevURL   string
+	NextURL   string
+}
+
+// serveGallery lists the images in the pool as a grid of thumbnails linking
+// to the full images, galleryPageSize per page as selected by the page
+// query parameter.
+func serveGallery(w http.ResponseWriter, r *http.Request) {
+	page, err := queryInt(r, "page", 1)
+	if err != nil || page < 1 {
+		http.Error(w, "invalid page", http.StatusBadRequest)
+		return
+	}
+	var images []string
+	if mapper := primary.mapper.Load(); mapper != nil {
+		images = mapper.Images()
+	}
+	// Pages past the last are refused before multiplying, which could
+	// overflow
+	if pages := (len(images) + galleryPageSize - 1) / galleryPageSize; page > 1 && page > pages {
+		http.NotFound(w, r)
+		return
+	}
+	start := (page - 1) * galleryPageSize
+	end := min(start+galleryPageSize, len(images))
+
+	data := galleryData{Lang: requestLang(r), ThumbSize: galleryThumbSize}
+	data.Text = catalog[data.Lang]
+	for _, name := range images[start:end] {
+		link := imageURL(name)
+		data.Images = append(data.Images, galleryImage{
+			Name:     name,
+			URL:      link,
+			ThumbURL: link + "?w=" + strconv.Itoa(galleryThumbSize) + "&h=" + strconv.Itoa(galleryThumbSize),
+			IsVideo:  isVideo(name),
+		})
+	}
+	if page > 1 {
+		data.PrevURL = "/gallery?page=" + strconv.Itoa(page-1)
+	}
+	if end < len(images) {
+		data.NextURL = "/gallery?page=" + strconv.Itoa(page+1)
+	}
+
+	var buf bytes.Buffer
+	if err := galleryTemplate.Execute(&buf, data); err != nil {
+		errorLogger.Printf("Error rendering gallery: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Type", "text/html")
+	buf.WriteTo(w)
+}
+
+// imageURL returns the URL of the pool image name under /image/.
+func imageURL(name string) string {
+	return "/image/" + (&url.URL{Path: name}).EscapedPath()
+}
+
+// datePattern matches the dates in /image/ paths, as opposed to filenames.
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// serveImage serves /image/<date> with the image selected for that date
+// and /image/<filename> with the named image from the pool.
+func serveImage(w http.ResponseWriter, r *http.Request) {
+	if datePattern.MatchString(strings.TrimPrefix(r.URL.Path, "/image/")) {
+		serveDateImage(w, r)
+		return
+	}
+	serveNamedImage(w, r)
+}
+
+// serveNamedImage serves the pool image named in the path, e.g.
+// /image/premium/a.jpg. Only names in the current pool are served, so the
+// path can't reach other files. The w, h and format query parameters
+// request a resized or converted variant, as for /today.
+func serveNamedImage(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/image/")
+	mapper := primary.mapper.Load()
+	if slices.Contains(strings.Split(name, "/"), "..") || mapper == nil || !mapper.Contains(name) {
+		http.NotFound(w, r)
+		return
+	}
+	path, err := sourcePath(name)
+	if err != nil {
+		errorLogger.Printf("Error fetching %s: %v", name, err)
+		http.Error(w, "image unavailable", http.StatusBadGateway)
+		return
+	}
+	serveImageOrVariant(w, r, path)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeGalleryPages(t *testing.T) {
+	setupTest(t)
+	defer func(c *collection, dir string) { primary, imageDir = c, dir }(primary, imageDir)
+	imageDir = t.TempDir()
+	for _, name := range testImages(galleryPageSize + 1) {
+		writeTestJPEG(t, filepath.Join(imageDir, name))
+	}
+	primary = newCollection("", imageDir, assetDir)
+	primary.update()
+
+	tests := []struct {
+		query    string
+		wantCode int
+	}{
+		{"", http.StatusOK},
+		{"?page=2", http.StatusOK},
+		{"?page=3", http.StatusNotFound},
+		{"?page=9223372036854775807", http.StatusNotFound},
+		{"?page=0", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serveGallery(w, httptest.NewRequest(http.MethodGet, "/gallery"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
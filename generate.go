@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runGenerate renders today's page and copies today's image into an output
+// directory, so the site can be hosted without running the server.
+func runGenerate(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	registerFlags(fs)
+	var outDir string
+	fs.StringVar(&outDir, "out", "public", "Output directory for the generated site")
+	fs.Parse(args)
+
+	closeLog := setup()
+	defer closeLog()
+
+	images, err := loadImages()
+	if err != nil {
+		errorLogger.Fatalf("Error getting image list: %v", err)
+	}
+	if len(images) == 0 {
+		errorLogger.Fatalf("No images available in the image directory")
+	}
+
+	today := time.Now().In(location)
+	selectedImage, err := selectImage(newMapper(images), today, region)
+	if err != nil {
+		errorLogger.Fatalf("Error selecting image for today: %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Join(outDir, "assets"), 0755)
+	if err != nil {
+		errorLogger.Fatalf("Failed to create output directory: %v", err)
+	}
+
+	srcPath, err := sourcePath(selectedImage)
+	if err != nil {
+		errorLogger.Fatalf("Error fetching today's image: %v", err)
+	}
+
+	// Named by content as in install, so a cached copy of an earlier image
+	// is never served in its place
+	sum, err := hashFile(srcPath)
+	if err != nil {
+		errorLogger.Fatalf("Error fetching today's image: %v", err)
+	}
+	newImageName := fmt.Sprintf("today_%s_%s%s", periodLabel(today), sum[:8], filepath.Ext(selectedImage))
+	err = copyFile(srcPath, filepath.Join(outDir, "assets", newImageName))
+	if err != nil {
+		errorLogger.Fatalf("Error copying image to output directory: %v", err)
+	}
+
+	// The image is decoded once for the scaled copies and favicon
+	still := !isVideo(selectedImage) && !isAnimatedGIF(srcPath)
+	var img image.Image
+	var format string
+	if still || faviconMode == "image" && !isVideo(selectedImage) {
+		if img, format, err = decodeImage(srcPath); err != nil {
+			errorLogger.Fatalf("Error decoding today's image: %v", err)
+		}
+	}
+
+	var srcset []srcsetImage
+	if still {
+		if srcset, err = writeSrcset(img, format, filepath.Join(outDir, "assets"), newImageName); err != nil {
+			errorLogger.Fatalf("Error writing scaled copies of today's image: %v", err)
+		}
+	}
+
+	// Static hosts serve /favicon.ico from the output directory as well
+	switch {
+	case faviconMode == "image" && !isVideo(selectedImage):
+		var icon []byte
+		if icon, err = makeFavicon(img); err == nil {
+			err = os.WriteFile(filepath.Join(outDir, "favicon.ico"), icon, 0644)
+		}
+	case faviconMode == "icon" && faviconFile != "":
+		err = copyFile(faviconFile, filepath.Join(outDir, "favicon.ico"))
+	case faviconMode == "icon":
+		err = os.WriteFile(filepath.Join(outDir, "favicon.ico"), generatedIcon(), 0644)
+	}
+	if err != nil {
+		errorLogger.Fatalf("Error writing favicon: %v", err)
+	}
+
+	// Relative URLs keep the site working when hosted below the root
+	data := newPageData(newImageName)
+	data.ImageURL = "assets/" + newImageName
+	data.Srcset = srcsetAttr("assets/", srcset)
+	data.Date = today.Format("2006-01-02")
+	loadLinks()
+	data.Link = linkFor(selectedImage)
+	data.Caption = readCaption(srcPath, selectedImage)
+	data.CrossFade = false // Static hosts can't answer the script's JSON requests, so it reloads instead
+	if err := writeIndex(filepath.Join(outDir, "index.html"), data); err != nil {
+		errorLogger.Fatalf("Error rendering index.html: %v", err)
+	}
+
+	// Remove the images of previous runs once the new page is live
+	keep := map[string]bool{newImageName: true}
+	for _, scaled := range srcset {
+		keep[scaled.Filename] = true
+	}
+	if err := removeStaleAssets(filepath.Join(outDir, "assets"), keep); err != nil {
+		errorLogger.Printf("Error removing previous images: %v", err)
+	}
+
+	logger.Printf("Generated site in %s with today's image: %s", outDir, selectedImage)
+}
+
+// writeIndex renders the page with data to path. It's written to a
+// temporary file that is renamed into place, so a static host never serves
+// it half-written.
+func writeIndex(path string, data pageData) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := renderPage(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// removeStaleAssets removes the images of previous runs from dir, i.e. the
+// today_* files, with their scaled copies, that aren't in keep.
+func removeStaleAssets(dir string, keep map[string]bool) error {
+	stale, err := filepath.Glob(filepath.Join(dir, "today_*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if keep[filepath.Base(path)] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		debugLogger.Printf("Removed previous image: %s", filepath.Base(path))
+	}
+	return nil
+}
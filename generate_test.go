@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestRemoveStaleAssets(t *testing.T) {
+	setupTest(t)
+	dir := t.TempDir()
+	for _, name := range []string{"today_2024-01-01.jpg", "today_2024-01-01_640w.jpg", "today_2024-01-02.png", "today_2024-01-02_640w.png", "logo.png"} {
+		writeTestFile(t, dir, name, []byte("x"))
+	}
+
+	keep := map[string]bool{"today_2024-01-02.png": true, "today_2024-01-02_640w.png": true}
+	if err := removeStaleAssets(dir, keep); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	// Only the images of the previous run are removed
+	if want := []string{"logo.png", "today_2024-01-02.png", "today_2024-01-02_640w.png"}; !slices.Equal(left, want) {
+		t.Errorf("left %v, want %v", left, want)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// draining makes /healthz fail so load balancers stop routing here, while
+// requests that still arrive are served normally.
+var draining atomic.Bool
+
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// serveDrain marks the instance as draining.
+func serveDrain(w http.ResponseWriter, r *http.Request) {
+	setDraining(w, r, true)
+}
+
+// serveUndrain marks the instance as healthy again.
+func serveUndrain(w http.ResponseWriter, r *http.Request) {
+	setDraining(w, r, false)
+}
+
+func setDraining(w http.ResponseWriter, r *http.Request, value bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	if draining.Swap(value) != value {
+		logger.Printf("Draining: %t", value)
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"draining": value})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// historyEntry records that Filename was shown on Date (2006-01-02).
+type historyEntry struct {
+	Date     string `json:"date"`
+	Filename string `json:"filename"`
+}
+
+// historyMutex serializes access to historyFile.
+var historyMutex = make(chan struct{}, 1)
+
+// readHistory parses the history file: one "date\tfilename" line per shown
+// image, oldest first. A missing file has no entries.
+func readHistory() ([]historyEntry, error) {
+	file, err := os.Open(historyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		date, filename, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected date and filename", historyFile, line)
+		}
+		entries = append(entries, historyEntry{Date: date, Filename: filename})
+	}
+	return entries, scanner.Err()
+}
+
+// recordHistory appends today's selectedImage to the history file. Days
+// missed since the last entry, e.g. while the server was down, are filled
+// in first with what mapper picks for them. Nothing is written if
+// selectedImage is already the last entry.
+func recordHistory(mapper *ImageMapper, today time.Time, selectedImage string) error {
+	historyMutex <- struct{}{}        // Lock
+	defer func() { <-historyMutex }() // Unlock
+
+	entries, err := readHistory()
+	if err != nil {
+		return err
+	}
+	date := today.Format("2006-01-02")
+
+	var lines []string
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		if last.Date == date && last.Filename == selectedImage {
+			return nil
+		}
+		lastDate, err := time.ParseInLocation("2006-01-02", last.Date, location)
+		if err != nil {
+			return fmt.Errorf("invalid date in history: %q", last.Date)
+		}
+		for day := lastDate.AddDate(0, 0, 1); day.Format("2006-01-02") < date; day = day.AddDate(0, 0, 1) {
+			missed, err := selectImage(mapper, day, region)
+			if err != nil {
+				return fmt.Errorf("backfilling %s: %w", day.Format("2006-01-02"), err)
+			}
+			lines = append(lines, day.Format("2006-01-02")+"\t"+missed+"\n")
+		}
+		if len(lines) > 0 {
+			logger.Printf("Backfilled %d missed days in the history", len(lines))
+		}
+	}
+	lines = append(lines, date+"\t"+selectedImage+"\n")
+
+	file, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(strings.Join(lines, "")); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// serveHistory lists the images shown so far, oldest first.
+func serveHistory(w http.ResponseWriter, r *http.Request) {
+	historyMutex <- struct{}{}        // Lock
+	defer func() { <-historyMutex }() // Unlock
+
+	entries, err := readHistory()
+	if err != nil {
+		errorLogger.Printf("Error reading history: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "history unavailable"})
+		return
+	}
+	if entries == nil {
+		entries = []historyEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+
+	"golang.org/x/text/language"
+)
+
+// messages holds the localized text of the page.
+type messages struct {
+	Title       string `json:"title"`
+	Heading     string `json:"heading"`
+	Subtitle    string `json:"subtitle"`
+	Stale       string `json:"stale"`
+	WeekHeading string `json:"weekHeading"`
+	NoImage     string `json:"noImage"`
+
+	GalleryHeading string `json:"galleryHeading"`
+	PreviousPage   string `json:"previousPage"`
+	NextPage       string `json:"nextPage"`
+
+	Yesterday string `json:"yesterday"`
+	Tomorrow  string `json:"tomorrow"`
+}
+
+// fallbackLang is the language every message is translated to, and the
+// default of -lang.
+const fallbackLang = "en"
+
+//go:embed messages.json
+var messagesJSON []byte
+
+// catalog maps lowercase language tags to their messages.
+var catalog = func() map[string]messages {
+	var c map[string]messages
+	if err := json.Unmarshal(messagesJSON, &c); err != nil {
+		panic("invalid messages.json: " + err.Error())
+	}
+	if _, ok := c[fallbackLang]; !ok {
+		panic("messages.json lacks the fallback language " + fallbackLang)
+	}
+	return c
+}()
+
+// langTags lists the translated languages, fallbackLang first, and
+// langMatcher matches requested languages against them.
+var (
+	langTags    = append([]string{fallbackLang}, slices.DeleteFunc(catalogLangs(), func(lang string) bool { return lang == fallbackLang })...)
+	langMatcher = language.NewMatcher(func() []language.Tag {
+		tags := make([]language.Tag, len(langTags))
+		for i, lang := range langTags {
+			tags[i] = language.MustParse(lang)
+		}
+		return tags
+	}())
+)
+
+// requestLang picks the language of the page for r: the lang query
+// parameter if it's translated, otherwise the best match for the
+// Accept-Language header, falling back to pageLang.
+func requestLang(r *http.Request) string {
+	if lang, ok := matchLang(r.URL.Query().Get("lang")); ok {
+		return lang
+	}
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return pageLang
+	}
+	if _, i, confidence := langMatcher.Match(tags...); confidence != language.No {
+		return langTags[i]
+	}
+	return pageLang
+}
+
+// catalogLangs returns the translated languages, sorted.
+func catalogLangs() []string {
+	return slices.Sorted(maps.Keys(catalog))
+}
+
+// matchLang returns the translated language for tag, e.g. de for de-AT.
+func matchLang(tag string) (string, bool) {
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return "", false
+	}
+	if _, i, confidence := langMatcher.Match(parsed); confidence != language.No {
+		return langTags[i], true
+	}
+	return "", false
+}
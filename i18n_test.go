@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestLang(t *testing.T) {
+	defer func(lang string) { pageLang = lang }(pageLang)
+	pageLang = "nl"
+	tests := []struct {
+		query, header string
+		want          string
+	}{
+		{"", "", "nl"},
+		{"", "de", "de"},
+		{"", "de-AT,en;q=0.5", "de"},
+		{"", "fr-CA;q=0.8, es-419", "es"},
+		{"", "en-GB, de;q=0.9", "en"},
+		{"", "pt-BR, fr;q=0.3", "fr"},
+		{"", "de;q=0, fr", "fr"},
+		{"", "pt, ja", "nl"},
+		{"", "*", "nl"},
+		{"", "not a language!", "nl"},
+		{"lang=DE", "fr", "de"},
+		{"lang=es_MX", "fr", "es"},
+		{"lang=xx", "fr", "fr"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/?"+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("Accept-Language", tt.header)
+		}
+		if got := requestLang(r); got != tt.want {
+			t.Errorf("requestLang(%q, Accept-Language %q) = %q, want %q", tt.query, tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// checkImageSize reads only the header of the image at path and rejects it
+// if it exceeds maxPixels, so huge images are never fully decoded.
+func checkImageSize(path string) (image.Config, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	if maxPixels > 0 && config.Width*config.Height > maxPixels {
+		return config, format, fmt.Errorf("%dx%d exceeds the limit of %d pixels", config.Width, config.Height, maxPixels)
+	}
+	return config, format, nil
+}
+
+// decodeImage decodes the image at path after checking its dimensions
+// against maxPixels. It also returns the format, sniffed from the contents.
+func decodeImage(path string) (image.Image, string, error) {
+	if _, _, err := checkImageSize(path); err != nil {
+		return nil, "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	return image.Decode(file)
+}
+
+// isAnimatedGIF reports whether the file at path is a GIF of more than one
+// frame, which can't be scaled or converted without losing all but the
+// first. Only the block structure is read, not the image data.
+func isAnimatedGIF(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var header [13]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || !bytes.HasPrefix(header[:], []byte("GIF8")) {
+		return false
+	}
+	// Global color table
+	if header[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << (header[10]&7 + 1)); err != nil {
+			return false
+		}
+	}
+	frames := 0
+	for {
+		block, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch block {
+		case 0x21: // Extension: label and data sub-blocks
+			if _, err := r.ReadByte(); err != nil || skipSubBlocks(r) != nil {
+				return false
+			}
+		case 0x2c: // Image descriptor: local color table, LZW code size and data sub-blocks
+			if frames++; frames > 1 {
+				return true
+			}
+			var descriptor [9]byte
+			if _, err := io.ReadFull(r, descriptor[:]); err != nil {
+				return false
+			}
+			skip := 1
+			if descriptor[8]&0x80 != 0 {
+				skip += 3 << (descriptor[8]&7 + 1)
+			}
+			if _, err := r.Discard(skip); err != nil || skipSubBlocks(r) != nil {
+				return false
+			}
+		default: // Trailer
+			return false
+		}
+	}
+}
+
+// skipSubBlocks skips GIF data sub-blocks up to the terminating empty one.
+func skipSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil || size == 0 {
+			return err
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// scaleToFit returns img scaled down to fit within maxW x maxH, keeping the
+// aspect ratio; a bound of 0 is ignored. It's resampled with Catmull-Rom.
+// Images that already fit are copied unscaled.
+func scaleToFit(img image.Image, maxW, maxH int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := srcW, srcH
+	if maxW > 0 && dstW > maxW {
+		dstW, dstH = maxW, max(1, srcH*maxW/srcW)
+	}
+	if maxH > 0 && dstH > maxH {
+		dstW, dstH = max(1, srcW*maxH/srcH), maxH
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	if dstW == srcW && dstH == srcH {
+		draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	}
+	return dst
+}
+
+// cropSquare returns the largest square centered in img.
+func cropSquare(img image.Image) image.Image {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	square := image.Rect(x, y, x+side, y+side)
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(square)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), img, square.Min, draw.Src)
+	return dst
+}
+
+// glyphs is a tiny 3x5 bitmap font covering what's needed to label dates.
+var glyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'-': {"...", "...", "###", "...", "..."},
+}
+
+// drawLabel draws text with glyphs onto img, with its top left corner at
+// (x, y) and every font pixel scaled to a scale x scale square. Runes
+// without a glyph are left blank.
+func drawLabel(img draw.Image, x, y, scale int, text string, c color.Color) {
+	for _, ch := range text {
+		glyph := glyphs[ch]
+		for row, line := range glyph {
+			for col, bit := range line {
+				if bit != '#' {
+					continue
+				}
+				px := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(img, px, image.NewUniform(c), image.Point{}, draw.Src)
+			}
+		}
+		x += 4 * scale
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsAnimatedGIF(t *testing.T) {
+	dir := t.TempDir()
+	animated := filepath.Join(dir, "animated.gif")
+	writeTestGIF(t, animated, 2)
+	still := filepath.Join(dir, "still.gif")
+	writeTestGIF(t, still, 1)
+	png := filepath.Join(dir, "still.png")
+	writeTestPNG(t, png)
+	full, err := os.ReadFile(animated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut off in the second frame
+	truncated := writeTestFile(t, dir, "truncated.gif", full[:len(full)-8])
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"two frames", animated, true},
+		{"one frame", still, false},
+		{"png", png, false},
+		{"truncated", truncated, true},
+		{"empty", writeTestFile(t, dir, "empty.gif", nil), false},
+		{"missing", filepath.Join(dir, "missing.gif"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAnimatedGIF(tt.path); got != tt.want {
+				t.Errorf("isAnimatedGIF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeVariantAnimatedGIF(t *testing.T) {
+	setupTest(t)
+	path := filepath.Join(t.TempDir(), "animated.gif")
+	writeTestGIF(t, path, 2)
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		width       int
+		format      string
+		passThrough bool
+	}{
+		{"original", 0, "", true},
+		{"scaled", 4, "", true},
+		{"scaled gif", 4, "gif", true},
+		{"png", 0, "png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serveVariant(w, httptest.NewRequest("GET", "/today.gif", nil), path, tt.width, 0, tt.format)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d", w.Code)
+			}
+			if got := bytes.Equal(w.Body.Bytes(), original); got != tt.passThrough {
+				t.Errorf("passed through = %v, want %v", got, tt.passThrough)
+			}
+		})
+	}
+}
+
+// writeTestGIF writes an 8x8 GIF to path whose frames each have a
+// different color.
+func writeTestGIF(t *testing.T, path string, frames int) {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White, color.RGBA{255, 0, 0, 255}}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i % len(palette))
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 50)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := gif.EncodeAll(file, anim); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScaleToFit(t *testing.T) {
+	src := image.NewRGBA(image.Rect(10, 20, 410, 220))
+	fill := color.RGBA{R: 0x8b, G: 0x5a, B: 0x2b, A: 0xff}
+	for y := src.Bounds().Min.Y; y < src.Bounds().Max.Y; y++ {
+		for x := src.Bounds().Min.X; x < src.Bounds().Max.X; x++ {
+			src.SetRGBA(x, y, fill)
+		}
+	}
+
+	tests := []struct {
+		maxW, maxH int
+		wantW      int
+		wantH      int
+	}{
+		{100, 100, 100, 50},
+		{0, 40, 80, 40},
+		{200, 0, 200, 100},
+		{1000, 1000, 400, 200},
+		{0, 0, 400, 200},
+		{1, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		dst := scaleToFit(src, tt.maxW, tt.maxH)
+		if dx, dy := dst.Bounds().Dx(), dst.Bounds().Dy(); dx != tt.wantW || dy != tt.wantH {
+			t.Errorf("scaleToFit(%d, %d) is %dx%d, want %dx%d", tt.maxW, tt.maxH, dx, dy, tt.wantW, tt.wantH)
+		}
+		// A plain image stays plain, up to the edges
+		for _, p := range []image.Point{{}, {dst.Bounds().Dx() - 1, dst.Bounds().Dy() - 1}} {
+			if got := dst.RGBAAt(p.X, p.Y); got != fill {
+				t.Errorf("scaleToFit(%d, %d) at %v = %v, want %v", tt.maxW, tt.maxH, p, got, fill)
+			}
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexEntry is an image listed by the -index-url service. Only URL is
+// required; Name defaults to the last element of the URL path.
+type indexEntry struct {
+	Name   string  `json:"name"`
+	URL    string  `json:"url"`
+	Size   int64   `json:"size"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Format string  `json:"format"`
+	Weight float64 `json:"weight"` // Defaults to the filename suffix, e.g. @3
+}
+
+// indexCacheDir is the subdirectory of assetDir that images listed by the
+// index are downloaded into.
+const indexCacheDir = "index"
+
+var (
+	indexClient = &http.Client{Timeout: 30 * time.Second}
+
+	// remoteCtx cancels fetches from indexClient. main sets it to the
+	// server's lifetime, so a download doesn't hold up shutdown.
+	remoteCtx = context.Background()
+
+	// The last index fetched successfully, kept when a later fetch fails.
+	indexMutex  sync.Mutex
+	indexImages []imageInfo
+	indexURLs   map[string]string
+
+	// Downloads in progress by destination, so concurrent requests for an
+	// image fetch it only once while other images download alongside.
+	downloadMutex sync.Mutex
+	downloading   = make(map[string]*pendingDownload)
+
+	// downloadCache sizes the downloads by path, removing the least recently
+	// used once they exceed -index-cache-size. Nil means no limit.
+	downloadCache *lruCache[string, int64]
+)
+
+// pendingDownload is a download in progress. err is set before done is
+// closed.
+type pendingDownload struct {
+	done chan struct{}
+	err  error
+}
+
+// remoteImages reports whether the pool is listed by -index-url or -urls
+// rather than read from the image directory.
+func remoteImages() bool {
+	return indexURL != "" || urlsFile != ""
+}
+
+// loadIndex fetches the index at indexURL, or reads the URL list in
+// urlsFile, and returns its images. If that fails, the last-known-good
+// index is returned instead, if any.
+func loadIndex() ([]imageInfo, error) {
+	var images []imageInfo
+	var urls map[string]string
+	var err error
+	if urlsFile != "" {
+		images, urls, err = readURLList(urlsFile)
+	} else {
+		images, urls, err = fetchIndex(indexURL)
+	}
+
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	if err != nil {
+		if indexURLs == nil {
+			return nil, err
+		}
+		errorLogger.Printf("Error fetching image index, keeping the previous one: %v", err)
+		return indexImages, nil
+	}
+	indexImages, indexURLs = images, urls
+	return images, nil
+}
+
+// fetchIndex downloads and parses the index at rawURL. Relative image URLs
+// are resolved against it.
+func fetchIndex(rawURL string) ([]imageInfo, map[string]string, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := remoteGet(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("index returned %s", resp.Status)
+	}
+
+	var entries []indexEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, nil, fmt.Errorf("invalid index: %w", err)
+	}
+	images, urls := indexImagesOf(base, entries)
+	return images, urls, nil
+}
+
+// readURLList reads a list of image URLs from path, one per line, skipping
+// blank lines and lines starting with #. Images are named after the last
+// element of their URL path, as in an index without names.
+func readURLList(path string) ([]imageInfo, map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var entries []indexEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if ref, err := url.Parse(line); err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+			warnLogger.Printf("Skipping invalid image URL %q", line)
+			continue
+		}
+		entries = append(entries, indexEntry{URL: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	images, urls := indexImagesOf(&url.URL{}, entries)
+	return images, urls, nil
+}
+
+// indexImagesOf returns the images of entries and their URLs by name.
+// Relative URLs are resolved against base.
+func indexImagesOf(base *url.URL, entries []indexEntry) ([]imageInfo, map[string]string) {
+	var images []imageInfo
+	urls := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		ref, err := base.Parse(entry.URL)
+		if err != nil || entry.URL == "" {
+			warnLogger.Printf("Skipping index entry with invalid URL %q", entry.URL)
+			continue
+		}
+		name := entry.Name
+		if name == "" {
+			name = path.Base(ref.Path)
+		}
+		if !isMedia(name) || !matchesGlobs(name) {
+			continue
+		}
+		if _, ok := urls[name]; ok {
+			warnLogger.Printf("Skipping duplicate index entry %s", name)
+			continue
+		}
+		urls[name] = ref.String()
+
+		format := entry.Format
+		if format == "" {
+			format = strings.TrimPrefix(filepath.Ext(name), ".")
+		}
+		weight := entry.Weight
+		if weight <= 0 {
+			weight = weightFromName(name)
+		}
+		images = append(images, imageInfo{
+			Filename: name,
+			Size:     entry.Size,
+			Width:    entry.Width,
+			Height:   entry.Height,
+			Format:   format,
+			Weight:   weight,
+		})
+	}
+	return images, urls
+}
+
+// sourcePath returns the local path of the image name in the pool. Images
+// listed by the index or URL list are downloaded on first use.
+func sourcePath(name string) (string, error) {
+	if !remoteImages() {
+		return filepath.Join(imageDir, filepath.FromSlash(name)), nil
+	}
+
+	indexMutex.Lock()
+	rawURL, ok := indexURLs[name]
+	indexMutex.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%s is not in the image index", name)
+	}
+
+	// Name the download after its URL, so a changed URL is fetched again
+	sum := sha256.Sum256([]byte(rawURL))
+	dest := filepath.Join(assetDir, indexCacheDir, hex.EncodeToString(sum[:16])+filepath.Ext(name))
+
+	downloadMutex.Lock()
+	if _, err := os.Stat(dest); err == nil {
+		downloadMutex.Unlock()
+		downloadCache.Get(dest) // Mark it as recently used
+		return dest, nil
+	}
+	pending, ok := downloading[dest]
+	if !ok {
+		pending = &pendingDownload{done: make(chan struct{})}
+		downloading[dest] = pending
+	}
+	downloadMutex.Unlock()
+
+	if ok {
+		<-pending.done
+	} else {
+		pending.err = download(rawURL, dest)
+		if info, err := os.Stat(dest); err == nil {
+			downloadCache.Add(dest, info.Size())
+		}
+		downloadMutex.Lock()
+		delete(downloading, dest)
+		downloadMutex.Unlock()
+		close(pending.done)
+	}
+	if pending.err != nil {
+		return "", fmt.Errorf("downloading %s: %w", name, pending.err)
+	}
+	return dest, nil
+}
+
+// download saves the body of rawURL to dest, which only appears once the
+// download is complete.
+func download(rawURL, dest string) error {
+	resp, err := remoteGet(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// loadDownloads sets up downloadCache from -index-cache-size with the images
+// downloaded by earlier runs, keeping the most recently downloaded longest.
+func loadDownloads() error {
+	if indexCacheSize == 0 {
+		return nil
+	}
+	downloadCache = newLRUCache(int64(indexCacheSize)<<20, func(size int64) int64 { return size }, evictDownload)
+
+	dir := filepath.Join(assetDir, indexCacheDir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var infos []fs.FileInfo
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			infos = append(infos, info)
+		}
+	}
+	slices.SortFunc(infos, func(a, b fs.FileInfo) int { return a.ModTime().Compare(b.ModTime()) })
+	for _, info := range infos {
+		downloadCache.Add(filepath.Join(dir, info.Name()), info.Size())
+	}
+	return nil
+}
+
+// evictDownload removes a download evicted from downloadCache. Today's image
+// is kept, since -no-copy serves it from there.
+func evictDownload(path string, _ int64) {
+	if primary != nil && primary.image().path == path {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		warnLogger.Printf("Error removing downloaded image: %v", err)
+	} else {
+		debugLogger.Printf("Removed downloaded image %s", filepath.Base(path))
+	}
+}
+
+// remoteGet fetches rawURL with indexClient until remoteCtx is done.
+func remoteGet(rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(remoteCtx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return indexClient.Do(req)
+}
+
+// scheduleIndexRefresh refetches the index every indexInterval until ctx is
+// done, so entries added to it are considered for future dates.
+func scheduleIndexRefresh(ctx context.Context) {
+	ticker := time.NewTicker(indexInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if !primary.lock("refreshing the index") {
+			continue
+		}
+		images, err := loadImages()
+		if err == nil {
+			primary.store(images)
+		}
+		primary.unlock()
+	}
+}
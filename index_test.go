@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setupIndexTest serves images from a test server, listing each name in
+// images as a URL on it, and returns the server's request count per path.
+// Requests for paths in block wait until it is closed.
+func setupIndexTest(t *testing.T, images []string, block map[string]chan struct{}) map[string]*atomic.Int32 {
+	t.Helper()
+	setupTest(t)
+	requests := make(map[string]*atomic.Int32)
+	for _, name := range images {
+		requests["/"+name] = new(atomic.Int32)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, ok := requests[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		count.Add(1)
+		if wait, ok := block[r.URL.Path]; ok {
+			<-wait
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	t.Cleanup(server.Close)
+
+	oldURLsFile, oldURLs, oldCache := urlsFile, indexURLs, downloadCache
+	t.Cleanup(func() { urlsFile, indexURLs, downloadCache = oldURLsFile, oldURLs, oldCache })
+	urlsFile = "urls.txt"
+	indexURLs = make(map[string]string)
+	for _, name := range images {
+		indexURLs[name] = server.URL + "/" + name
+	}
+	downloadCache = nil
+	return requests
+}
+
+func TestSourcePathDownloadsOnce(t *testing.T) {
+	requests := setupIndexTest(t, []string{"a.jpg", "b.jpg"}, nil)
+
+	tests := []struct {
+		name     string
+		requests int
+	}{
+		{"a.jpg", 20},
+		{"b.jpg", 5},
+	}
+	var wg sync.WaitGroup
+	for _, tt := range tests {
+		for i := 0; i < tt.requests; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := sourcePath(tt.name); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, tt := range tests {
+		if got := requests["/"+tt.name].Load(); got != 1 {
+			t.Errorf("%s fetched %d times, want once", tt.name, got)
+		}
+	}
+}
+
+func TestSourcePathDownloadsInParallel(t *testing.T) {
+	slow := make(chan struct{})
+	setupIndexTest(t, []string{"slow.jpg", "fast.jpg"}, map[string]chan struct{}{"/slow.jpg": slow})
+
+	slowDone := make(chan error)
+	go func() {
+		_, err := sourcePath("slow.jpg")
+		slowDone <- err
+	}()
+	defer func() {
+		close(slow)
+		if err := <-slowDone; err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// A slow download mustn't hold up the others
+	done := make(chan error)
+	go func() {
+		_, err := sourcePath("fast.jpg")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download waited for another image's download")
+	}
+}
+
+func TestSourcePathEvictsDownloads(t *testing.T) {
+	images := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}
+
+	tests := []struct {
+		name     string
+		capacity int64
+		use      []string
+		kept     []string
+		evicted  []string
+	}{
+		{"no limit", 0, images, images, nil},
+		{"under the limit", 400, images, images, nil},
+		{"oldest evicted", 250, images, []string{"c.jpg", "d.jpg"}, []string{"a.jpg", "b.jpg"}},
+		{"recently used kept", 250, []string{"a.jpg", "b.jpg", "a.jpg", "c.jpg"}, []string{"a.jpg", "c.jpg"}, []string{"b.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupIndexTest(t, images, nil)
+			if tt.capacity > 0 {
+				downloadCache = newLRUCache(tt.capacity, func(size int64) int64 { return size }, evictDownload)
+			}
+
+			paths := make(map[string]string)
+			for _, name := range tt.use {
+				path, err := sourcePath(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				paths[name] = path
+			}
+			for _, name := range tt.kept {
+				if _, err := os.Stat(paths[name]); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+			for _, name := range tt.evicted {
+				if _, err := os.Stat(paths[name]); err == nil {
+					t.Errorf("%s is still downloaded", name)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDownloads(t *testing.T) {
+	requests := setupIndexTest(t, []string{"a.jpg", "b.jpg"}, nil)
+	defer func(old int) { indexCacheSize = old }(indexCacheSize)
+	indexCacheSize = 1
+
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if _, err := sourcePath(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A later run knows the downloads and fetches them no more
+	if err := loadDownloads(); err != nil {
+		t.Fatal(err)
+	}
+	if got := downloadCache.Size(); got != 200 {
+		t.Errorf("downloadCache.Size() = %d, want 200", got)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if _, err := sourcePath(name); err != nil {
+			t.Fatal(err)
+		}
+		if got := requests["/"+name].Load(); got != 1 {
+			t.Errorf("%s fetched %d times, want once", name, got)
+		}
+	}
+}
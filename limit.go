@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limitConcurrency rejects requests with 503 while limit requests are
+// already in flight. /healthz is exempt, so probes keep working.
+func limitConcurrency(next http.Handler, limit int) http.Handler {
+	inFlight := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case inFlight <- struct{}{}:
+			defer func() { <-inFlight }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests in flight", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// rateLimitIdle is how long a client's bucket is kept after its last
+// request; by then it has refilled completely anyway.
+const rateLimitIdle = 10 * time.Minute
+
+// tokenBucket holds the tokens a client has left, as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limitRate answers clients exceeding rate requests per second, with bursts
+// of up to burst requests, with 429. Clients are told apart by clientIP.
+// Idle buckets are dropped periodically until ctx is done. /healthz is
+// exempt.
+func limitRate(ctx context.Context, next http.Handler, rate float64, burst int) http.Handler {
+	var mu sync.Mutex
+	buckets := make(map[string]*tokenBucket)
+
+	go func() {
+		ticker := time.NewTicker(rateLimitIdle)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				mu.Lock()
+				for ip, bucket := range buckets {
+					if now.Sub(bucket.last) > rateLimitIdle {
+						delete(buckets, ip)
+					}
+				}
+				mu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		now := time.Now()
+
+		mu.Lock()
+		bucket, ok := buckets[ip]
+		if !ok {
+			bucket = &tokenBucket{tokens: float64(burst), last: now}
+			buckets[ip] = bucket
+		}
+		bucket.tokens = min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+		bucket.last = now
+		allowed := bucket.tokens >= 1
+		if allowed {
+			bucket.tokens--
+		}
+		wait := (1 - bucket.tokens) / rate
+		mu.Unlock()
+
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address of the client that sent r. With -trust-proxy,
+// the last address in X-Forwarded-For is used, which is the one the proxy in
+// front of us appended.
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
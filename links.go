@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"sync/atomic"
+)
+
+// imageLinks maps pool filenames to the URL their image links to when
+// clicked, as read from linksFile.
+var imageLinks atomic.Pointer[map[string]string]
+
+// loadLinks reads the JSON object in linksFile, mapping filenames to link
+// targets. Targets that aren't absolute http(s) URLs are skipped. If the file
+// can't be read, the previous links are kept.
+func loadLinks() {
+	if linksFile == "" {
+		return
+	}
+	data, err := os.ReadFile(linksFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			imageLinks.Store(nil)
+		} else {
+			errorLogger.Printf("Error reading links file: %v", err)
+		}
+		return
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		errorLogger.Printf("Error parsing links file: %v", err)
+		return
+	}
+	links := make(map[string]string, len(raw))
+	for name, target := range raw {
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			warnLogger.Printf("Skipping invalid link for %s: %q", name, target)
+			continue
+		}
+		links[name] = u.String()
+	}
+	imageLinks.Store(&links)
+}
+
+// linkFor returns the link target of the pool image name, or "" if it has
+// none.
+func linkFor(name string) string {
+	links := imageLinks.Load()
+	if links == nil {
+		return ""
+	}
+	return (*links)[name]
+}
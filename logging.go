@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Loggers per level. logger is the info level one. Levels below -loglevel
+// are discarded. accessLogger writes the access log, whatever the level.
+var (
+	debugLogger  *log.Logger
+	warnLogger   *log.Logger
+	errorLogger  *log.Logger
+	accessLogger *log.Logger
+)
+
+// parseLogLevel parses the -loglevel flag.
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("must be debug, info, warn or error")
+}
+
+// setLoggers points the loggers at out. The plain format writes classic
+// timestamped lines; text and json write structured records with log/slog.
+func setLoggers(out io.Writer, format string, level slog.Level) {
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+	case "text":
+		handler = slog.NewTextHandler(out, &slog.HandlerOptions{Level: level})
+	}
+
+	newLogger := func(l slog.Level) *log.Logger {
+		switch {
+		case l < level:
+			return log.New(io.Discard, "", 0)
+		case handler != nil:
+			return slog.NewLogLogger(handler, l)
+		}
+		return log.New(out, "", log.LstdFlags)
+	}
+	debugLogger = newLogger(slog.LevelDebug)
+	logger = newLogger(slog.LevelInfo)
+	warnLogger = newLogger(slog.LevelWarn)
+	errorLogger = newLogger(slog.LevelError)
+
+	// Access log lines carry their own timestamp in the plain format
+	switch format {
+	case "json":
+		accessLogger = slog.NewLogLogger(slog.NewJSONHandler(out, nil), slog.LevelInfo)
+	case "text":
+		accessLogger = slog.NewLogLogger(slog.NewTextHandler(out, nil), slog.LevelInfo)
+	default:
+		accessLogger = log.New(out, "", 0)
+	}
+}
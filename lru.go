@@ -0,0 +1,86 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache holds values up to a total size, evicting the least recently used
+// ones first. A nil or zero-capacity cache holds nothing.
+type lruCache[K comparable, V any] struct {
+	mutex    sync.Mutex
+	capacity int64
+	size     int64
+	order    *list.List // Of *lruEntry, most recently used first
+	entries  map[K]*list.Element
+	sizeOf   func(V) int64
+	evicted  func(K, V) // Called for each entry evicted, with the cache locked
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRUCache[K comparable, V any](capacity int64, sizeOf func(V) int64, evicted func(K, V)) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[K]*list.Element),
+		sizeOf:   sizeOf,
+		evicted:  evicted,
+	}
+}
+
+// Get returns the value cached for key and marks it as recently used.
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry[K, V]).value, true
+}
+
+// Add caches value for key, evicting old entries to make room. Values larger
+// than the whole cache are not cached.
+func (c *lruCache[K, V]) Add(key K, value V) {
+	if c == nil || c.sizeOf(value) > c.capacity {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	for c.size+c.sizeOf(value) > c.capacity {
+		oldest := c.order.Back()
+		entry := c.order.Remove(oldest).(*lruEntry[K, V])
+		delete(c.entries, entry.key)
+		c.size -= c.sizeOf(entry.value)
+		if c.evicted != nil {
+			c.evicted(entry.key, entry.value)
+		}
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	c.size += c.sizeOf(value)
+}
+
+// Size returns the total size of the values cached.
+func (c *lruCache[K, V]) Size() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLRUCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int64
+		add      []string // Added in order; "get:k" looks k up instead
+		want     []string
+		evicted  []string
+	}{
+		{"fits", 10, []string{"aa", "bbb"}, []string{"aa", "bbb"}, nil},
+		{"oldest evicted", 5, []string{"aa", "bbb", "c"}, []string{"bbb", "c"}, []string{"aa"}},
+		{"get keeps", 5, []string{"aa", "bbb", "get:aa", "cc"}, []string{"aa", "cc"}, []string{"bbb"}},
+		{"added again keeps", 5, []string{"aa", "bbb", "aa", "cc"}, []string{"aa", "cc"}, []string{"bbb"}},
+		{"too large", 2, []string{"a", "bbb"}, []string{"a"}, nil},
+		{"zero capacity", 0, []string{"a"}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var evicted []string
+			cache := newLRUCache(tt.capacity, func(value string) int64 { return int64(len(value)) }, func(key, _ string) {
+				evicted = append(evicted, key)
+			})
+			for _, key := range tt.add {
+				if name, ok := strings.CutPrefix(key, "get:"); ok {
+					cache.Get(name)
+				} else {
+					cache.Add(key, key)
+				}
+			}
+
+			var size int64
+			for _, key := range tt.want {
+				if _, ok := cache.Get(key); !ok {
+					t.Errorf("%s isn't cached", key)
+				}
+				size += int64(len(key))
+			}
+			if got := cache.Size(); got != size {
+				t.Errorf("Size() = %d, want %d", got, size)
+			}
+			if !slices.Equal(evicted, tt.evicted) {
+				t.Errorf("evicted %v, want %v", evicted, tt.evicted)
+			}
+		})
+	}
+
+	var cache *lruCache[string, string]
+	cache.Add("a", "a")
+	if _, ok := cache.Get("a"); ok || cache.Size() != 0 {
+		t.Error("nil cache holds a value")
+	}
+}
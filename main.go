@@ -1,29 +1,115 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
-	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"sort"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
-	imageDir     string
-	assetDir     string
-	logFile      string
-	port         string
-	timezoneName string
-	logger       *log.Logger
-	location     *time.Location
-	imageMutex   = make(chan struct{}, 1) // Mutex to prevent concurrent writes
+	imageDir              string
+	assetDir              string
+	logFile               string
+	port                  string
+	timezoneName          string
+	adminToken            string
+	excludeRecent         int
+	region                string
+	serveSpec             bool
+	assetNoCase           bool
+	enableVideo           bool
+	faviconMode           string
+	faviconFile           string
+	maxPixels             int
+	featuredFile          string
+	accessLogFormat       string
+	renewJitter           time.Duration
+	renewOffset           time.Duration // Random delay in [0, renewJitter] chosen at startup
+	hideStaleNote         bool
+	includeGlob           string
+	excludeGlob           string
+	showWeek              bool
+	noJS                  bool
+	variantCacheSize      int
+	indexCacheSize        int
+	poolsConfig           string
+	poolWeights           []poolWeight // Parsed from poolsConfig, sorted by name
+	transition            string
+	transitionDuration    time.Duration
+	indexURL              string
+	indexInterval         time.Duration
+	linksFile             string
+	maxConcurrent         int
+	stickySelection       bool
+	settleTime            time.Duration
+	selectionMetricsLimit int
+	todayCacheControl     string
+	variantPercent        int
+	variantTemplateFile   string
+	variantAssetDir       string
+	checkReadable         bool
+	randomSeed            uint64
+	playlistFile          string
+	playlistDuplicates    string
+	headless              bool
+	templateFile          string
+	watch                 bool
+	watchInterval         time.Duration
+	watchPollInterval     time.Duration
+	seed                  string
+	logLevel              string
+	logFormat             string
+	createImageDir        bool
+	thumbSize             int
+	rateLimit             float64
+	rateBurst             int
+	trustProxy            bool
+	collectionsDir        string
+	defaultCollection     string
+	historyFile           string
+	interval              time.Duration
+	pageLang              string
+	noCopy                bool
+	strategy              string
+	addr                  string
+	tlsCert               string
+	tlsKey                string
+	redirectHTTP          string
+	dedupe                string
+	anniversary           bool
+	anniversaryDays       int
+	urlsFile              string
+	fallbackImage         string
+	pprofAddr             string
+	configFile            string
+	srcsetConfig          string
+	srcsetWidths          []int // Parsed from srcsetConfig, ascending
+	syncCopies            bool
+	lockTimeout           time.Duration
+	dayNav                bool
+	statusFile            string
+	logger                *log.Logger
+	location              *time.Location
 )
 
 func init() {
@@ -31,159 +117,1086 @@ func init() {
 }
 
 func main() {
-	// Command-line flags
-	flag.StringVar(&imageDir, "imagedir", getEnv("IMAGE_DIR", "images"), "Directory containing all images")
-	flag.StringVar(&assetDir, "assetdir", getEnv("ASSET_DIR", "assets"), "Directory for assets (serving the image)")
-	flag.StringVar(&logFile, "logfile", getEnv("LOG_FILE", ""), "Log file path (leave empty to disable file logging)")
-	flag.StringVar(&port, "port", getEnv("PORT", "8080"), "Port to serve (default 8080)")
-	flag.StringVar(&timezoneName, "timezone", getEnv("TIMEZONE", "CET"), "Timezone for image renewal (default CET)")
+	// Subcommands run once and exit instead of starting the server
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+
+	registerFlags(flag.CommandLine)
 	flag.Parse()
 
-	// Load the specified timezone
-	var err error
-	location, err = time.LoadLocation(timezoneName)
-	if err != nil {
-		log.Fatalf("Failed to load timezone '%s': %v", timezoneName, err)
-	}
+	closeLog := setup()
+	defer closeLog()
 
-	// Set up logging
-	logger = log.New(os.Stdout, "", log.LstdFlags)
-	if logFile != "" {
-		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	// Without -no-copy, today's image is copied into the asset directory
+	if !noCopy {
+		// Ensure asset directory exists
+		err := os.MkdirAll(assetDir, 0755)
 		if err != nil {
-			logger.Printf("Failed to open log file: %v", err)
-		} else {
-			multiWriter := io.MultiWriter(os.Stdout, file)
-			logger = log.New(multiWriter, "", log.LstdFlags)
-			defer file.Close()
+			errorLogger.Fatalf("Failed to create asset directory: %v", err)
+		}
+
+		// Dated assets written into imageDir would end up in the pool
+		if sameDir(assetDir, imageDir) {
+			errorLogger.Fatalf("Asset directory %q must not be the same as image directory %q", assetDir, imageDir)
 		}
 	}
 
-	// Ensure asset directory exists
-	err = os.MkdirAll(assetDir, 0755)
-	if err != nil {
-		logger.Fatalf("Failed to create asset directory: %v", err)
+	if err := loadCollections(); err != nil {
+		errorLogger.Fatalf("Error loading collections: %v", err)
+	}
+	loadFeatured()
+
+	// A missing image directory is most likely a misconfiguration
+	if !remoteImages() {
+		if err := ensureImageDir(imageDir); err != nil {
+			errorLogger.Fatalf("Error checking image directory: %v", err)
+		}
+	} else if err := loadDownloads(); err != nil {
+		errorLogger.Printf("Error reading downloaded images: %v", err)
 	}
 
 	// Initial image update
-	updateImageForToday()
+	updated := primary.update()
+	if updated {
+		verifySelection()
+	}
+	secondary := secondaryCollections()
+	secondaryUpdated := make([]bool, len(secondary))
+	for i, c := range secondary {
+		secondaryUpdated[i] = c.update()
+	}
+
+	// Stop on SIGINT or SIGTERM, e.g. from docker stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	remoteCtx = ctx
+	defer stop()
 
 	// Schedule image updates
-	go scheduleImageUpdates()
+	go scheduleImageUpdates(ctx, primary, updated)
+	for i, c := range secondary {
+		go scheduleImageUpdates(ctx, c, secondaryUpdated[i])
+	}
+	if remoteImages() {
+		go scheduleIndexRefresh(ctx)
+	}
+	if watch && !remoteImages() {
+		go watchImages(ctx)
+	}
 
-	// Serve HTTP
-	http.HandleFunc("/", servePage)
-	http.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir(assetDir))))
+	// Serve HTTP. Routes go on a mux of their own rather than
+	// http.DefaultServeMux, which net/http/pprof registers itself on.
+	mux := http.NewServeMux()
+	registerRoutes(mux)
 
-	// Serve todays image for favicon
-	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(assetDir, assetImageFilename))
-	})
+	var handler http.Handler = mux
+	if assetNoCase {
+		handler = normalizeAssetPath(handler)
+	}
+	if maxConcurrent > 0 {
+		handler = limitConcurrency(handler, maxConcurrent)
+	}
+	if rateLimit > 0 {
+		handler = limitRate(ctx, handler, rateLimit, rateBurst)
+	}
+	handler = recoverPanics(handler)
+	if accessLogFormat != "" {
+		handler = logAccess(handler)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		errorLogger.Fatalf("Server failed: %v", err)
+	}
+	server := &http.Server{Handler: handler}
+	if tlsCert != "" {
+		if server.TLSConfig, err = tlsConfig(); err != nil {
+			errorLogger.Fatalf("Error loading TLS certificate: %v", err)
+		}
+	}
+
+	// Plain HTTP requests are redirected by a server of their own
+	var redirectServer *http.Server
+	if redirectHTTP != "" {
+		redirectListener, err := net.Listen("tcp", redirectHTTP)
+		if err != nil {
+			errorLogger.Fatalf("Redirect server failed: %v", err)
+		}
+		redirectServer = &http.Server{Handler: redirectToHTTPS(listener.Addr().(*net.TCPAddr).Port)}
+		go func() {
+			if err := redirectServer.Serve(redirectListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errorLogger.Fatalf("Redirect server failed: %v", err)
+			}
+		}()
+		logger.Printf("Redirecting HTTP on %s to HTTPS", redirectListener.Addr())
+	}
+
+	// Profiling endpoints are kept off the main listener
+	var pprofServer *http.Server
+	if pprofAddr != "" {
+		pprofListener, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			errorLogger.Fatalf("Profiling server failed: %v", err)
+		}
+		pprofServer = &http.Server{Handler: pprofHandler()}
+		go func() {
+			if err := pprofServer.Serve(pprofListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errorLogger.Fatalf("Profiling server failed: %v", err)
+			}
+		}()
+		logger.Printf("Serving profiling endpoints on %s under /debug/pprof/", pprofListener.Addr())
+	}
+
+	go func() {
+		<-ctx.Done()
+		logger.Println("Shutting down gracefully")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if redirectServer != nil {
+			if err := redirectServer.Shutdown(shutdownCtx); err != nil {
+				errorLogger.Printf("Error shutting down the redirect server: %v", err)
+			}
+		}
+		if pprofServer != nil {
+			if err := pprofServer.Shutdown(shutdownCtx); err != nil {
+				errorLogger.Printf("Error shutting down the profiling server: %v", err)
+			}
+		}
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			errorLogger.Printf("Error shutting down: %v", err)
+		}
+	}()
+
+	if interval > 0 {
+		logger.Printf("Server started on %s. Images will be renewed every %v from midnight in timezone '%s'.", listener.Addr(), interval, timezoneName)
+	} else {
+		logger.Printf("Server started on %s. Images will be renewed at midnight in timezone '%s'.", listener.Addr(), timezoneName)
+	}
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errorLogger.Fatalf("Server failed: %v", err)
+	}
+
+	// Let an image update that's in progress finish, within -lock-timeout
+	primary.lock("waiting for the update")
+	for _, c := range secondary {
+		c.lock("waiting for the update")
+	}
+	logger.Println("Server stopped")
+}
+
+// router is the part of http.ServeMux that routes are registered on, so tests
+// can enumerate them.
+type router interface {
+	Handle(pattern string, handler http.Handler)
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// registerRoutes registers the routes enabled by the flags on mux.
+func registerRoutes(mux router) {
+	if headless {
+		mux.HandleFunc("/{$}", serveTodayInfo)
+	} else {
+		mux.HandleFunc("/", servePage)
+	}
+	var assetFS http.FileSystem = http.Dir(assetDir)
+	if assetNoCase {
+		assetFS = caseInsensitiveDir{http.Dir(assetDir)}
+	}
+	mux.Handle("/assets/", http.StripPrefix("/assets/", cacheAssets(http.FileServer(assetFS))))
+
+	if variantAssetDir != "" {
+		mux.Handle("/variant/assets/", http.StripPrefix("/variant/assets/", http.FileServer(http.Dir(variantAssetDir))))
+	}
+
+	if collectionsDir != "" {
+		mux.HandleFunc("/c/{name}", serveCollectionPage)
+		mux.HandleFunc("/c/{name}/today", serveCollectionToday)
+		mux.HandleFunc("/c/{name}/assets/{file}", serveCollectionAsset)
+	}
+
+	mux.HandleFunc("/healthz", serveHealth)
+	// Besides ours, the metrics of the Go runtime and the process
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/today", serveToday)
+	mux.HandleFunc("/week", serveWeek)
+	mux.HandleFunc("/random", serveRandom)
+	mux.HandleFunc("/image/", serveImage)
+	mux.HandleFunc("/api/today", serveTodayInfo)
+	mux.HandleFunc("/api/week", serveWeekInfo)
+	if historyFile != "" {
+		mux.HandleFunc("/api/history", serveHistory)
+	}
+
+	// Serve todays image (or an icon) for favicon, the gallery and the
+	// placeholder of the empty page
+	if !headless {
+		mux.HandleFunc("/gallery", serveGallery)
+		mux.HandleFunc("/favicon.ico", serveFavicon)
+		mux.HandleFunc("/no-image.svg", serveNoImage)
+	}
+
+	if serveSpec {
+		mux.HandleFunc("/openapi.json", serveOpenAPI)
+	}
+
+	// Admin endpoints are only available with a token configured
+	if adminToken != "" {
+		mux.HandleFunc("/admin/rescan", requireAdmin(serveRescan))
+		mux.HandleFunc("/admin/refresh", requireAdmin(serveRefresh))
+		mux.HandleFunc("/admin/feature", requireAdmin(serveFeature))
+		mux.HandleFunc("/admin/clear-feature", requireAdmin(serveClearFeature))
+		mux.HandleFunc("/admin/week.png", requireAdmin(serveWeekPreview))
+		mux.HandleFunc("/api/images", requireAdmin(serveImageList))
+		mux.HandleFunc("/api/upcoming", requireAdmin(serveUpcoming))
+		mux.HandleFunc("/admin/drain", requireAdmin(serveDrain))
+		mux.HandleFunc("/admin/undrain", requireAdmin(serveUndrain))
+		mux.HandleFunc("/admin/export.zip", requireAdmin(serveExport))
+	}
+}
+
+// shutdownTimeout is how long in-flight requests may take to finish on
+// shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// subcommands maps command-line subcommands to their entry points. Each one
+// parses its own arguments, including the common flags.
+var subcommands = map[string]func(args []string){
+	"generate":    runGenerate,
+	"manifest":    runManifest,
+	"verify":      runVerify,
+	"testvectors": runTestVectors,
+	"diff":        runDiff,
+	"plan":        runPlan,
+}
+
+// registerFlags defines the common command-line flags on fs.
+func registerFlags(fs *flag.FlagSet) {
+	loadConfig(os.Args[1:])
+	defer warnUnknownConfigKeys()
+
+	fs.StringVar(&configFile, "config", getEnv("CONFIG_FILE", ""), "YAML or TOML file of settings, keyed by environment variable name, e.g. image_dir; the environment and command line override them")
+	fs.StringVar(&imageDir, "imagedir", getEnv("IMAGE_DIR", "images"), "Directory containing all images")
+	fs.StringVar(&assetDir, "assetdir", getEnv("ASSET_DIR", "assets"), "Directory for assets (serving the image)")
+	fs.StringVar(&logFile, "logfile", getEnv("LOG_FILE", ""), "Log file path (leave empty to disable file logging)")
+	fs.StringVar(&port, "port", getEnv("PORT", "8080"), "Port to serve on all interfaces (default 8080)")
+	fs.StringVar(&addr, "addr", getEnv("ADDR", ""), "Address to serve on, e.g. 127.0.0.1:8080, taking precedence over -port")
+	fs.StringVar(&tlsCert, "tls-cert", getEnv("TLS_CERT", ""), "Certificate file to serve HTTPS with, together with -tls-key")
+	fs.StringVar(&tlsKey, "tls-key", getEnv("TLS_KEY", ""), "Private key file of -tls-cert")
+	fs.StringVar(&redirectHTTP, "redirect-http", getEnv("REDIRECT_HTTP", ""), "Address, e.g. :80, to redirect plain HTTP requests to HTTPS from (requires -tls-cert)")
+	fs.StringVar(&timezoneName, "timezone", getEnv("TIMEZONE", "CET"), "Timezone for image renewal: an IANA name like Europe/Berlin, Local, or an offset like UTC+2 (default CET)")
+	fs.StringVar(&adminToken, "admin-token", getEnv("ADMIN_TOKEN", ""), "Bearer token for /admin endpoints (leave empty to disable them)")
+	fs.IntVar(&excludeRecent, "exclude-recent", getEnvInt("EXCLUDE_RECENT", 0), "Number of previous days whose images are excluded from today's pick (0 disables)")
+	fs.IntVar(&excludeRecent, "no-repeat", getEnvInt("NO_REPEAT", getEnvInt("EXCLUDE_RECENT", 0)), "Alias of -exclude-recent")
+	fs.BoolVar(&serveSpec, "openapi", getEnvBool("OPENAPI", false), "Serve the OpenAPI document at /openapi.json")
+	fs.BoolVar(&assetNoCase, "asset-ignore-case", getEnvBool("ASSET_IGNORE_CASE", false), "Match /assets paths case-insensitively")
+	fs.BoolVar(&enableVideo, "video", getEnvBool("VIDEO", false), "Include .mp4 and .webm clips in the pool")
+	fs.StringVar(&faviconMode, "favicon-mode", getEnv("FAVICON_MODE", "image"), "Favicon behavior: image (today's image), icon or off")
+	fs.StringVar(&faviconFile, "favicon-file", getEnv("FAVICON_FILE", ""), "Icon served in icon favicon mode (leave empty for a generated one)")
+	fs.IntVar(&maxPixels, "max-pixels", getEnvInt("MAX_PIXELS", 100_000_000), "Skip images with more pixels than this (0 disables the check)")
+	fs.StringVar(&featuredFile, "featured-file", getEnv("FEATURED_FILE", "featured.txt"), "File persisting the featured image across restarts")
+	fs.StringVar(&accessLogFormat, "access-log-format", getEnv("ACCESS_LOG_FORMAT", ""), "Log every request in common or combined log format (leave empty for the default request log)")
+	fs.DurationVar(&renewJitter, "renew-jitter", getEnvDuration("RENEW_JITTER", 0), "Maximum random delay added to the midnight renewal, chosen once per run")
+	fs.BoolVar(&hideStaleNote, "hide-stale-note", getEnvBool("HIDE_STALE_NOTE", false), "Don't mention on the page when a previous day's image is still shown")
+	fs.StringVar(&includeGlob, "include-glob", getEnv("INCLUDE_GLOB", ""), "Comma-separated filename patterns; only matching images are used")
+	fs.StringVar(&excludeGlob, "exclude-glob", getEnv("EXCLUDE_GLOB", ""), "Comma-separated filename patterns of images to leave out")
+	fs.BoolVar(&showWeek, "show-week", getEnvBool("SHOW_WEEK", false), "Show the image of the week below the daily image")
+	fs.BoolVar(&noJS, "no-js", getEnvBool("NO_JS", false), "Serve the page without any scripts, refreshing it with a meta tag instead")
+	fs.IntVar(&variantCacheSize, "variant-cache-size", getEnvInt("VARIANT_CACHE_SIZE", 32), "Memory in MB for caching resized and converted images (0 disables caching)")
+	fs.StringVar(&poolsConfig, "pools", getEnv("POOLS", ""), "Comma-separated name=weight pairs of weighted pools, each a subdirectory of the image directory")
+	fs.StringVar(&transition, "transition", getEnv("TRANSITION", "none"), "Image transition on the page: fade or none")
+	fs.DurationVar(&transitionDuration, "transition-duration", getEnvDuration("TRANSITION_DURATION", time.Second), "Duration of the fade transition")
+	fs.StringVar(&indexURL, "index-url", getEnv("INDEX_URL", ""), "URL of a JSON image index to use instead of the image directory")
+	fs.IntVar(&indexCacheSize, "index-cache-size", getEnvInt("INDEX_CACHE_SIZE", 1024), "Disk space in MB for images downloaded from the index or URL list (0 for no limit)")
+	fs.DurationVar(&indexInterval, "index-interval", getEnvDuration("INDEX_INTERVAL", time.Hour), "How often the image index is fetched again")
+	fs.StringVar(&urlsFile, "urls", getEnv("URLS_FILE", ""), "File listing image URLs, one per line, to download today's image from instead of using the image directory")
+	fs.StringVar(&linksFile, "links-file", getEnv("LINKS_FILE", ""), "JSON file mapping image filenames to the URLs they link to")
+	fs.IntVar(&maxConcurrent, "max-concurrent", getEnvInt("MAX_CONCURRENT", 0), "Maximum number of requests handled at once, answering others with 503 (0 is unlimited)")
+	fs.BoolVar(&stickySelection, "sticky-selection", getEnvBool("STICKY_SELECTION", false), "Base the page's selections on the date of the scheduled image instead of the request time")
+	fs.DurationVar(&settleTime, "settle-time", getEnvDuration("SETTLE_TIME", 0), "Skip images modified more recently than this, e.g. while a sync is still writing them (0 disables)")
+	fs.IntVar(&selectionMetricsLimit, "selection-metrics-limit", getEnvInt("SELECTION_METRICS_LIMIT", 1000), "Largest pool for which selections are counted per image (0 only keeps the total)")
+	fs.StringVar(&todayCacheControl, "today-cache-control", getEnv("TODAY_CACHE_CONTROL", ""), "Cache-Control header for /today (leave empty for a short max-age ending at the next renewal)")
+	fs.IntVar(&variantPercent, "variant-percent", getEnvInt("VARIANT_PERCENT", 0), "Percentage of clients, by IP address, served the variant page")
+	fs.StringVar(&variantTemplateFile, "variant-template", getEnv("VARIANT_TEMPLATE", ""), "HTML template of the variant page, receiving the same data as the default one")
+	fs.StringVar(&variantAssetDir, "variant-assetdir", getEnv("VARIANT_ASSET_DIR", ""), "Directory of additional files for the variant page, served at /variant/assets/")
+	fs.BoolVar(&checkReadable, "check-readable", getEnvBool("CHECK_READABLE", true), "Open every image while scanning and leave out those that can't be read")
+	fs.Uint64Var(&randomSeed, "random-seed", uint64(getEnvInt("RANDOM_SEED", 0)), "Seed for random choices like the renewal jitter, making them deterministic (0 seeds from the clock)")
+	fs.StringVar(&playlistFile, "playlist-file", getEnv("PLAYLIST_FILE", ""), "File listing images, one per line, to show in order instead of picking by hash")
+	fs.StringVar(&playlistDuplicates, "playlist-duplicates", getEnv("PLAYLIST_DUPLICATES", "skip"), "How repeated playlist entries are handled: skip, keep or error")
+	fs.BoolVar(&headless, "headless", getEnvBool("HEADLESS", false), "Serve only the API and images: / answers with today's JSON and there's no HTML page or favicon")
+	fs.StringVar(&templateFile, "template", getEnv("TEMPLATE", ""), "HTML template file for the page (leave empty for the built-in one)")
+	fs.BoolVar(&watch, "watch", getEnvBool("WATCH", false), "Renew today's image when files in the image directory change")
+	fs.DurationVar(&watchInterval, "watch-interval", getEnvDuration("WATCH_INTERVAL", 2*time.Second), "How long the image directory must be unchanged before -watch renews today's image")
+	fs.DurationVar(&watchPollInterval, "watch-poll-interval", getEnvDuration("WATCH_POLL_INTERVAL", 30*time.Second), "How often -watch checks the image directory where change notifications aren't available")
+	fs.StringVar(&seed, "seed", getEnv("SEED", ""), "Salt mixed into the selection; instances with the same seed and images agree (leave empty for the default sequence)")
+	fs.StringVar(&logLevel, "loglevel", getEnv("LOG_LEVEL", "info"), "Minimum level of log messages: debug, info, warn or error")
+	fs.StringVar(&logFormat, "logformat", getEnv("LOG_FORMAT", "plain"), "Log format: plain, text or json")
+	fs.BoolVar(&createImageDir, "create-imagedir", getEnvBool("CREATE_IMAGE_DIR", false), "Create the image directory if it doesn't exist instead of exiting")
+	fs.IntVar(&thumbSize, "thumb-size", getEnvInt("THUMB_SIZE", 64), "Maximum width and height of the thumbnail of today's image, served as favicon if no icon can be made (0 disables thumbnails)")
+	fs.Float64Var(&rateLimit, "rate", getEnvFloat("RATE_LIMIT", 0), "Requests per second allowed per client IP, answering others with 429 (0 is unlimited)")
+	fs.IntVar(&rateBurst, "burst", getEnvInt("RATE_BURST", 10), "Requests a client IP may make at once before -rate applies")
+	fs.BoolVar(&trustProxy, "trust-proxy", getEnvBool("TRUST_PROXY", false), "Identify clients by the X-Forwarded-For header set by a reverse proxy")
+	fs.StringVar(&collectionsDir, "collections", getEnv("COLLECTIONS_DIR", ""), "Directory whose subdirectories are image collections served at /c/<name>")
+	fs.StringVar(&defaultCollection, "default-collection", getEnv("DEFAULT_COLLECTION", ""), "Collection from -collections served at / instead of the image directory")
+	fs.StringVar(&historyFile, "history", getEnv("HISTORY_FILE", ""), "File to append the date and filename of each day's image to, served at /api/history")
+	fs.DurationVar(&interval, "interval", getEnvDuration("INTERVAL", 0), "Change the image every interval, e.g. 1h or 6h, counted from midnight (0 changes it daily at midnight)")
+	fs.StringVar(&pageLang, "lang", getEnv("PAGE_LANG", fallbackLang), "Language of the page when the browser accepts none of the translated ones")
+	fs.BoolVar(&noCopy, "no-copy", getEnvBool("NO_COPY", false), "Serve today's image straight from the image directory instead of copying it to the asset directory")
+	fs.StringVar(&strategy, "strategy", getEnv("STRATEGY", "hash"), "How images are ordered: hash (stable as images come and go, honors weights and pools), sequential (sorted order) or random-shuffle (a new random order each round through the pool)")
+	fs.StringVar(&dedupe, "dedupe", getEnv("DEDUPE", ""), "Look for byte-identical images: warn logs them, collapse also keeps only one of each as candidate (leave empty to skip)")
+	fs.BoolVar(&anniversary, "anniversary", getEnvBool("ANNIVERSARY", false), "Show photos around the anniversary of the day they were taken, by the EXIF date of JPEGs, and undated images on other days")
+	fs.IntVar(&anniversaryDays, "anniversary-days", getEnvInt("ANNIVERSARY_DAYS", 3), "Days before and after the anniversary a photo may be shown with -anniversary")
+	fs.StringVar(&fallbackImage, "fallback", getEnv("FALLBACK_IMAGE", ""), "Image file served while today's image can't be selected or read, e.g. with an empty image directory")
+	fs.StringVar(&pprofAddr, "pprof", getEnv("PPROF", ""), "Address, e.g. localhost:6060, to serve profiling endpoints under /debug/pprof/ on (off by default)")
+	fs.StringVar(&srcsetConfig, "srcset-widths", getEnv("SRCSET_WIDTHS", "640,1280,1920"), "Comma-separated widths of scaled-down copies of today's image offered to browsers in a srcset (leave empty for none)")
+	fs.BoolVar(&syncCopies, "fsync", getEnvBool("FSYNC", false), "Flush copied images to disk before they replace the previous ones, so a crash can't leave a partial image behind")
+	fs.DurationVar(&lockTimeout, "lock-timeout", getEnvDuration("LOCK_TIMEOUT", 5*time.Minute), "How long to wait for a running image update, e.g. one stuck on a slow network mount, before giving up and logging it (0 waits indefinitely)")
+	fs.BoolVar(&dayNav, "day-nav", getEnvBool("DAY_NAV", false), "Show arrows on the page linking yesterday's image, with tomorrow's shown disabled")
+	fs.StringVar(&statusFile, "status-file", getEnv("STATUS_FILE", ""), "File replaced with the date and filename of today's image on every renewal, for scripts (leave empty to disable)")
+	fs.StringVar(&region, "region", getEnv("REGION", ""), "Region key giving this instance its own image sequence (leave empty for the default)")
+}
+
+// setup validates the parsed flags, loads the timezone and sets up logging.
+// The returned function closes the log file.
+func setup() func() {
+	if enableVideo {
+		for ext, contentType := range videoExtensions {
+			mime.AddExtensionType(ext, contentType)
+		}
+	}
+
+	if rateLimit < 0 {
+		log.Fatalf("Invalid rate %g: must not be negative", rateLimit)
+	}
+	if rateLimit > 0 && rateBurst < 1 {
+		log.Fatalf("Invalid burst %d: must be at least 1", rateBurst)
+	}
+
+	if defaultCollection != "" {
+		if collectionsDir == "" {
+			log.Fatalf("A default collection requires -collections")
+		}
+		imageDir = filepath.Join(collectionsDir, defaultCollection)
+	}
+
+	if interval < 0 || interval >= 24*time.Hour || interval%time.Minute != 0 || (interval > 0 && 24*time.Hour%interval != 0) {
+		log.Fatalf("Invalid interval %v: must divide a day into whole minutes", interval)
+	}
+
+	lang, ok := matchLang(pageLang)
+	if !ok {
+		log.Fatalf("Invalid language '%s': must be one of %s", pageLang, strings.Join(catalogLangs(), ", "))
+	}
+	pageLang = lang
+
+	if addr == "" {
+		addr = ":" + port
+	}
+	if err := checkAddr(addr); err != nil {
+		log.Fatalf("Invalid address '%s': %v", addr, err)
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		log.Fatalf("-tls-cert and -tls-key must be set together")
+	}
+	if redirectHTTP != "" {
+		if tlsCert == "" {
+			log.Fatalf("-redirect-http requires -tls-cert and -tls-key")
+		}
+		if err := checkAddr(redirectHTTP); err != nil {
+			log.Fatalf("Invalid redirect address '%s': %v", redirectHTTP, err)
+		}
+	}
+	if pprofAddr != "" {
+		if err := checkAddr(pprofAddr); err != nil {
+			log.Fatalf("Invalid pprof address '%s': %v", pprofAddr, err)
+		}
+	}
 
-	logger.Printf("Server started on :%s. Images will be renewed at midnight in timezone '%s'.", port, timezoneName)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		logger.Fatalf("Server failed: %v", err)
+	if fallbackImage != "" {
+		if info, err := os.Stat(fallbackImage); err != nil {
+			log.Fatalf("Invalid fallback image: %v", err)
+		} else if info.IsDir() || !isMedia(fallbackImage) {
+			log.Fatalf("Invalid fallback image '%s': must be an image file", fallbackImage)
+		}
 	}
+
+	if thumbSize < 0 {
+		log.Fatalf("Invalid thumbnail size %d: must not be negative", thumbSize)
+	}
+
+	switch faviconMode {
+	case "image", "icon", "off":
+	default:
+		log.Fatalf("Invalid favicon mode '%s': must be image, icon or off", faviconMode)
+	}
+
+	switch accessLogFormat {
+	case "", "common", "combined":
+	default:
+		log.Fatalf("Invalid access log format '%s': must be common or combined", accessLogFormat)
+	}
+
+	switch playlistDuplicates {
+	case "skip", "keep", "error":
+	default:
+		log.Fatalf("Invalid playlist duplicate policy '%s': must be skip, keep or error", playlistDuplicates)
+	}
+
+	if _, ok := strategies[strategy]; !ok {
+		log.Fatalf("Invalid strategy '%s': must be hash, sequential or random-shuffle", strategy)
+	}
+	if strategy != "hash" && (playlistFile != "" || poolsConfig != "") {
+		log.Fatalf("Strategy '%s' can't be combined with -playlist-file or -pools", strategy)
+	}
+	if anniversary && (strategy != "hash" || playlistFile != "" || poolsConfig != "") {
+		log.Fatalf("-anniversary can't be combined with -strategy, -playlist-file or -pools")
+	}
+	if anniversaryDays < 0 || anniversaryDays > 182 {
+		log.Fatalf("Invalid anniversary days %d: must be between 0 and 182", anniversaryDays)
+	}
+
+	switch dedupe {
+	case "", "warn", "collapse":
+	default:
+		log.Fatalf("Invalid dedupe mode '%s': must be warn or collapse", dedupe)
+	}
+
+	switch transition {
+	case "fade", "none":
+	default:
+		log.Fatalf("Invalid transition '%s': must be fade or none", transition)
+	}
+
+	seedRandom(randomSeed)
+	if renewJitter > 0 {
+		renewOffset = time.Duration(randomInt64N(int64(renewJitter) + 1))
+	}
+
+	for _, pattern := range append(splitList(includeGlob), splitList(excludeGlob)...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Fatalf("Invalid filename pattern '%s': %v", pattern, err)
+		}
+	}
+
+	var err error
+	if poolWeights, err = parsePools(poolsConfig); err != nil {
+		log.Fatalf("Invalid pools '%s': %v", poolsConfig, err)
+	}
+	if srcsetWidths, err = parseWidths(srcsetConfig); err != nil {
+		log.Fatalf("Invalid srcset widths '%s': %v", srcsetConfig, err)
+	}
+
+	if indexURL != "" && urlsFile != "" {
+		log.Fatalf("-index-url and -urls can't be combined")
+	}
+	if remoteImages() && len(poolWeights) > 0 {
+		log.Fatalf("Pools can't be combined with an image index or URL list")
+	}
+	if watchInterval <= 0 {
+		log.Fatalf("Invalid watch interval %v: must be positive", watchInterval)
+	}
+	if watchPollInterval <= 0 {
+		log.Fatalf("Invalid watch poll interval %v: must be positive", watchPollInterval)
+	}
+	if indexInterval <= 0 {
+		log.Fatalf("Invalid index interval %v: must be positive", indexInterval)
+	}
+	if indexCacheSize < 0 {
+		log.Fatalf("Invalid index cache size %d: must not be negative", indexCacheSize)
+	}
+
+	if variantPercent < 0 || variantPercent > 100 {
+		log.Fatalf("Invalid variant percentage %d: must be between 0 and 100", variantPercent)
+	}
+	if variantPercent > 0 && variantTemplateFile == "" {
+		log.Fatalf("A variant template is required to serve a variant (-variant-template)")
+	}
+	if err := loadTemplate(); err != nil {
+		log.Fatalf("Failed to load template: %v", err)
+	}
+	if err := loadVariant(); err != nil {
+		log.Fatalf("Failed to load variant template: %v", err)
+	}
+
+	variantCache = newVariantCache(int64(variantCacheSize) << 20)
+
+	// Load the specified timezone
+	location, err = loadLocation(timezoneName)
+	if err != nil {
+		log.Fatalf("Invalid timezone: %v", err)
+	}
+
+	// Set up logging
+	level, err := parseLogLevel(logLevel)
+	if err != nil {
+		log.Fatalf("Invalid log level '%s': %v", logLevel, err)
+	}
+	switch logFormat {
+	case "plain", "text", "json":
+	default:
+		log.Fatalf("Invalid log format '%s': must be plain, text or json", logFormat)
+	}
+	setLoggers(os.Stdout, logFormat, level)
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			warnLogger.Printf("Failed to open log file: %v", err)
+		} else {
+			multiWriter := io.MultiWriter(os.Stdout, file)
+			setLoggers(multiWriter, logFormat, level)
+			return func() { file.Close() }
+		}
+	}
+	return func() {}
 }
 
 func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
+	if value, exists := lookupEnv(key); exists {
 		return value
 	}
 	return fallback
 }
 
-func scheduleImageUpdates() {
-	for {
-		now := time.Now().In(location)
-		// Compute next midnight in the specified timezone
-		nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, location)
-		duration := nextMidnight.Sub(now)
+func getEnvInt(key string, fallback int) int {
+	if value, exists := lookupEnv(key); exists {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+			return fallback
+		}
+		return n
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, exists := lookupEnv(key); exists {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+			return fallback
+		}
+		return b
+	}
+	return fallback
+}
 
-		logger.Printf("Next image update in %v", duration)
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, exists := lookupEnv(key); exists {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+			return fallback
+		}
+		return f
+	}
+	return fallback
+}
 
-		time.Sleep(duration)
-		updateImageForToday()
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := lookupEnv(key); exists {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Ignoring invalid %s=%q: %v", key, value, err)
+			return fallback
+		}
+		return d
 	}
+	return fallback
 }
 
-var assetImageFilename = ""
+// nextRenewal returns when the image is renewed next after now: the next
+// midnight in the specified timezone, or with -interval the start of the
+// next period, plus the jitter offset.
+func nextRenewal(now time.Time) time.Time {
+	now = now.In(location)
+	if interval > 0 {
+		sinceMidnight := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+		next := (sinceMidnight/interval + 1) * interval
+		return nextWallClock(now, now.Year(), now.Month(), now.Day(), int(next.Seconds())).Add(renewOffset)
+	}
+	return nextWallClock(now, now.Year(), now.Month(), now.Day()+1, 0).Add(renewOffset)
+}
 
-func updateImageForToday() {
-	imageMutex <- struct{}{}        // Lock
-	defer func() { <-imageMutex }() // Unlock
+// nextWallClock returns the earliest instant after now at which the wall
+// clock in location reads the given day, sec seconds after midnight. Around
+// DST changes, time.Date may resolve a time the clock skips to before the
+// jump, e.g. a skipped midnight to 23:00 the day before, and a time that
+// repeats to a reading that already passed. So the readings in the zones
+// before and after are considered too, and a skipped time becomes the
+// instant the clock jumps past it.
+func nextWallClock(now time.Time, year int, month time.Month, day, sec int) time.Time {
+	t := time.Date(year, month, day, 0, 0, sec, 0, location)
+	wall := time.Date(year, month, day, 0, 0, sec, 0, time.UTC)
+	wallOf := func(t time.Time) time.Time {
+		t = t.In(location)
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
 
-	logger.Println("Updating image for today...")
+	// The same wall clock reading in the zone of t and its neighbors
+	_, offset := t.Zone()
+	start, end := t.ZoneBounds()
+	candidates := []time.Time{t}
+	for _, bound := range []time.Time{start, end} {
+		if bound.IsZero() {
+			continue
+		}
+		if bound == start {
+			bound = bound.Add(-time.Nanosecond) // In the zone before
+		}
+		_, other := bound.Zone()
+		candidates = append(candidates, t.Add(time.Duration(offset-other)*time.Second))
+	}
 
-	// Get list of images
-	images, err := getImageList(imageDir)
-	if err != nil {
-		logger.Printf("Error getting image list: %v", err)
-		return
+	var next time.Time
+	for _, candidate := range candidates {
+		if wallOf(candidate).Equal(wall) && candidate.After(now) && (next.IsZero() || candidate.Before(next)) {
+			next = candidate
+		}
+	}
+	if !next.IsZero() {
+		return next
 	}
 
-	if len(images) == 0 {
-		logger.Println("No images available in the image directory")
-		return
+	// The reading is skipped, so it's passed when the clock jumps
+	for _, bound := range []time.Time{start, end} {
+		if !bound.IsZero() && wallOf(bound).After(wall) && bound.After(now) && (next.IsZero() || bound.Before(next)) {
+			next = bound
+		}
+	}
+	if next.IsZero() {
+		return t
 	}
+	return next
+}
 
-	// Create ImageMapper
-	mapper := NewImageMapper(images)
+// periodLabel names the selection period containing t in asset filenames:
+// its date, followed with -interval by its start time, e.g. 2024-01-15T0600.
+func periodLabel(t time.Time) string {
+	t = t.In(location)
+	if interval <= 0 {
+		return t.Format("2006-01-02")
+	}
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	start := sinceMidnight / interval * interval
+	return fmt.Sprintf("%sT%02d%02d", t.Format("2006-01-02"), int(start.Hours()), int(start.Minutes())%60)
+}
 
-	// Get image for today
-	today := time.Now().In(location)
-	selectedImage, err := mapper.GetImageForDate(today)
-	if err != nil {
-		logger.Printf("Error selecting image for today: %v", err)
-		return
+// periodStarts returns the start of every selection period on the day of
+// t: midnight, and with -interval each interval after it. They're laid out
+// by the wall clock in minutes rather than added to midnight, which DST
+// would skew.
+func periodStarts(t time.Time) []time.Time {
+	step := 24 * time.Hour
+	if interval > 0 {
+		step = interval
+	}
+	var starts []time.Time
+	for offset := time.Duration(0); offset < 24*time.Hour; offset += step {
+		starts = append(starts, time.Date(t.Year(), t.Month(), t.Day(), 0, int(offset/time.Minute), 0, 0, location))
 	}
+	return starts
+}
+
+// Bounds of the delay between retries of a failed image update.
+const (
+	minRetryDelay = 10 * time.Second
+	maxRetryDelay = 10 * time.Minute
+)
 
-	// Remove existing image in asset directory
-	if assetImageFilename != "" {
-		err = os.Remove(filepath.Join(assetDir, assetImageFilename))
-		if err == nil {
-			logger.Printf("Removed previous image: %s", assetImageFilename)
+// scheduleImageUpdates renews the image of c at every renewal time until ctx
+// is done. While updating fails, starting with the initial update unless
+// updated is set, it retries with exponential backoff instead of waiting for
+// the next renewal.
+func scheduleImageUpdates(ctx context.Context, c *collection, updated bool) {
+	delay := minRetryDelay
+	for {
+		var wait time.Duration
+		if updated {
+			delay = minRetryDelay
+			now := time.Now().In(location)
+			target := nextRenewal(now)
+			wait = target.Sub(now)
+
+			if renewOffset > 0 {
+				logger.Printf("%sNext image update at %s (in %v)", c.logPrefix(), target.Format(time.DateTime), wait)
+			} else {
+				logger.Printf("%sNext image update in %v", c.logPrefix(), wait)
+			}
 		} else {
-			logger.Printf("Error removing previous image: %v", err)
+			wait = delay
+			delay = min(2*delay, maxRetryDelay)
+			logger.Printf("%sRetrying image update in %v", c.logPrefix(), wait)
 		}
-	} else {
-		logger.Println("No previous image to remove :) ")
-	}
 
-	// Copy selected image to asset directory with a unique name
-	srcPath := filepath.Join(imageDir, selectedImage)
+		select {
+		case <-time.After(wait):
+			updated = c.update()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-	newImageName := fmt.Sprintf("today_%s.jpg", today.Format("2006-01-02"))
-	destPath := filepath.Join(assetDir, newImageName)
+// selectImage picks the image for date in the sequence of key, honoring
+// -exclude-recent.
+func selectImage(mapper *ImageMapper, date time.Time, key string) (string, error) {
+	if excludeRecent > 0 {
+		return mapper.GetImageForDateExcludingRecent(date, key, excludeRecent)
+	}
+	return mapper.GetImageForDateWithKey(date, key)
+}
 
-	err = copyFile(srcPath, destPath)
-	if err != nil {
-		logger.Printf("Error copying image to asset directory: %v", err)
-		return
+// selectionDate returns the date the page's selections are made for: now,
+// or with -sticky-selection the time the scheduled image was selected at, so
+// requests straddling a renewal agree with it until the scheduler has run.
+func selectionDate() time.Time {
+	if selected := primary.image().time; stickySelection && !selected.IsZero() {
+		return selected
 	}
-	assetImageFilename = newImageName
+	return time.Now().In(location)
+}
+
+// previewImage is like selectImage, but also accepts future dates.
+func previewImage(mapper *ImageMapper, date time.Time, key string) (string, error) {
+	return mapper.imageForDate(date, key, excludeRecent, true)
+}
 
-	logger.Printf("Today's image: %s", selectedImage)
+// imageInfo describes an image found while scanning the image directory.
+type imageInfo struct {
+	Filename string      `json:"filename"`
+	Size     int64       `json:"size"`
+	Width    int         `json:"width"`
+	Height   int         `json:"height"`
+	Format   string      `json:"format"`
+	ModTime  time.Time   `json:"modtime"`
+	Weight   float64     `json:"weight"`
+	Taken    string      `json:"taken,omitempty"`  // Date from EXIF, only read with -anniversary
+	Window   *dateWindow `json:"window,omitempty"` // Dates the image is limited to by schedule.txt
 }
 
-func getImageList(dir string) ([]string, error) {
-	var images []string
+// scanImages walks dir and returns the metadata of every image in the pool.
+// Images are named by their slash-separated path relative to dir, so files
+// with the same name in different subdirectories stay distinct. The walk is
+// skipped if no directory changed since the last one; see cachedScan.
+func scanImages(dir string) ([]imageInfo, error) {
+	if err := ensureImageDir(dir); err != nil {
+		return nil, err
+	}
+	if images, ok := cachedScan(dir); ok {
+		debugLogger.Printf("Image directory %s unchanged, reusing the last scan", dir)
+		return images, nil
+	}
+
+	var images []imageInfo
+	dirs := make(map[string]time.Time)
+	complete := true // Whether no file was skipped for a reason that may pass
+	assetInfo, _ := os.Stat(assetDir)
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		// Check if it's a file and has .jpg or .jpeg extension
-		if !info.IsDir() && (filepath.Ext(info.Name()) == ".jpg" || filepath.Ext(info.Name()) == ".jpeg") {
-			images = append(images, info.Name())
+		// Skip the asset directory if it's nested inside the image directory
+		if info.IsDir() && assetInfo != nil && os.SameFile(info, assetInfo) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			dirs[path] = info.ModTime()
+			// A change within the timestamp granularity of some file
+			// systems could go unnoticed
+			if time.Since(info.ModTime()) < dirTimeGranularity {
+				complete = false
+			}
+		}
+		// Check if it's a file with a supported extension
+		if info.IsDir() || !isMedia(info.Name()) {
+			return nil
+		}
+		if !matchesGlobs(info.Name()) {
+			return nil
+		}
+		// Skip files that may still be being written
+		if age := time.Since(info.ModTime()); settleTime > 0 && age < settleTime {
+			warnLogger.Printf("Skipping image %s: modified %v ago", path, age.Round(time.Second))
+			complete = false
+			return nil
 		}
+
+		if checkReadable {
+			if err := checkOpen(path); err != nil {
+				warnLogger.Printf("Skipping unreadable image %s: %v", path, err)
+				complete = false
+				return nil
+			}
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		image := imageInfo{
+			Filename: filepath.ToSlash(rel),
+			Size:     info.Size(),
+			Format:   strings.TrimPrefix(filepath.Ext(info.Name()), "."),
+			ModTime:  info.ModTime(),
+			Weight:   weightFromName(info.Name()),
+		}
+		if !isVideo(info.Name()) {
+			config, format, err := checkImageSize(path)
+			// Skip images too large to decode safely
+			if err != nil && maxPixels > 0 {
+				warnLogger.Printf("Skipping image %s: %v", path, err)
+				return nil
+			}
+			if err == nil {
+				image.Width, image.Height, image.Format = config.Width, config.Height, format
+			}
+			if anniversary {
+				if taken, err := exifDate(path); err == nil {
+					image.Taken = taken.Format("2006-01-02")
+				}
+			}
+		}
+		images = append(images, image)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
+	if complete {
+		storeScan(dir, dirs, images)
+	}
+	return images, nil
+}
+
+// ensureImageDir checks that the image directory dir exists, creating it
+// with -create-imagedir.
+func ensureImageDir(dir string) error {
+	_, err := os.Stat(dir)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if !createImageDir {
+		return fmt.Errorf("image directory %q does not exist (use -create-imagedir to create it)", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create image directory: %w", err)
+	}
+	logger.Printf("Created image directory %s", dir)
+	return nil
+}
+
+// checkOpen reports whether the file at path can be opened for reading.
+func checkOpen(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// matchesGlobs reports whether name passes -include-glob and -exclude-glob.
+func matchesGlobs(name string) bool {
+	include := splitList(includeGlob)
+	if len(include) > 0 && !slices.ContainsFunc(include, func(pattern string) bool {
+		ok, _ := filepath.Match(pattern, name)
+		return ok
+	}) {
+		return false
+	}
+	return !slices.ContainsFunc(splitList(excludeGlob), func(pattern string) bool {
+		ok, _ := filepath.Match(pattern, name)
+		return ok
+	})
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// poolWeight configures a weighted pool in the image directory.
+type poolWeight struct {
+	name   string
+	weight float64
+}
+
+// parsePools parses the -pools flag.
+func parsePools(config string) ([]poolWeight, error) {
+	var pools []poolWeight
+	for _, item := range splitList(config) {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || name == "" || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("expected name=weight, got %q", item)
+		}
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight for pool %s: %q", name, value)
+		}
+		pools = append(pools, poolWeight{name: name, weight: weight})
+	}
+	slices.SortFunc(pools, func(a, b poolWeight) int { return strings.Compare(a.name, b.name) })
+	return pools, nil
+}
+
+// loadImages scans the image directory, or each configured pool in it. Pool
+// images are named relative to the image directory, e.g. premium/a.jpg. With
+// -index-url, the images listed by the index are used instead. Otherwise,
+// weights.txt in the image directory sets image weights and schedule.txt
+// their windows. With -playlist-file, the playlist is reread and compacted
+// to the images found.
+func loadImages() ([]imageInfo, error) {
+	images, err := loadPool()
+	if err != nil {
+		return nil, err
+	}
+	if !remoteImages() {
+		if err := applyWeights(imageDir, images); err != nil {
+			return nil, err
+		}
+		if err := applySchedule(imageDir, images); err != nil {
+			return nil, err
+		}
+		if dedupe != "" {
+			images = dedupeImages(imageDir, images)
+		}
+	}
+	if playlistFile == "" {
+		return images, nil
+	}
+
+	entries, err := readPlaylist(playlistFile)
+	if err != nil {
+		return nil, err
+	}
+	compacted, err := compactPlaylist(entries, images)
+	if err != nil {
+		return nil, err
+	}
+	if len(compacted) == 0 {
+		return nil, fmt.Errorf("no playlist entry is in the pool")
+	}
+	playlist = compacted
 	return images, nil
 }
 
+// loadPool returns the images of the pool, from the index or the image
+// directory.
+func loadPool() ([]imageInfo, error) {
+	if remoteImages() {
+		return loadIndex()
+	}
+	if len(poolWeights) == 0 {
+		return scanImages(imageDir)
+	}
+
+	var images []imageInfo
+	for _, pool := range poolWeights {
+		infos, err := scanImages(filepath.Join(imageDir, pool.name))
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			info.Filename = pool.name + "/" + info.Filename
+			images = append(images, info)
+		}
+	}
+	return images, nil
+}
+
+// newMapper creates the ImageMapper for images loaded by loadImages.
+func newMapper(images []imageInfo) *ImageMapper {
+	if playlistFile != "" {
+		return NewPlaylistImageMapper(playlist).WithInterval(interval)
+	}
+	if len(poolWeights) == 0 {
+		return NewImageMapper(imageNames(images)).WithWeights(imageWeights(images)).WithWindows(imageWindows(images)).WithSeed(seed).WithStrategy(selectionStrategy(images)).WithInterval(interval)
+	}
+
+	pools := make([]Pool, len(poolWeights))
+	for i, pool := range poolWeights {
+		pools[i] = Pool{Name: pool.name, Weight: pool.weight}
+		for _, image := range images {
+			if strings.HasPrefix(image.Filename, pool.name+"/") {
+				pools[i].Images = append(pools[i].Images, image.Filename)
+			}
+		}
+	}
+	return NewPooledImageMapper(pools).WithWeights(imageWeights(images)).WithWindows(imageWindows(images)).WithSeed(seed).WithStrategy(selectionStrategy(images)).WithInterval(interval)
+}
+
+// imageNames returns the filenames of images.
+func imageNames(images []imageInfo) []string {
+	names := make([]string, len(images))
+	for i, image := range images {
+		names[i] = image.Filename
+	}
+	return names
+}
+
+// checkAddr returns an error unless a is an address to listen on: an
+// optional host and a port from 0 to 65535.
+func checkAddr(a string) error {
+	_, p, err := net.SplitHostPort(a)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 65535 {
+		return errors.New("port must be a number from 0 to 65535")
+	}
+	return nil
+}
+
+// sameDir reports whether a and b refer to the same existing directory.
+func sameDir(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+var (
+	imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+	videoExtensions = map[string]string{".mp4": "video/mp4", ".webm": "video/webm"}
+)
+
+// isMedia reports whether name has an extension that belongs in the pool.
+func isMedia(name string) bool {
+	ext := filepath.Ext(name)
+	return imageExtensions[ext] || (enableVideo && isVideo(name))
+}
+
+// isVideo reports whether name has a video extension.
+func isVideo(name string) bool {
+	_, ok := videoExtensions[filepath.Ext(name)]
+	return ok
+}
+
+// copyFile copies src to dst through a temporary file in the same
+// directory that is renamed into place, so dst is never seen half-written.
+// The copy is read back and compared with the sha256 of what was read from
+// src, so a truncated or corrupted copy fails instead of replacing dst.
+// With -fsync, the copy and the rename are flushed to disk before
+// returning.
 func copyFile(src, dst string) error {
 	input, err := os.Open(src)
 	if err != nil {
@@ -191,117 +1204,64 @@ func copyFile(src, dst string) error {
 	}
 	defer input.Close()
 
+	output, err := os.CreateTemp(filepath.Dir(dst), ".copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(output.Name())
+
+	hash := sha256.New()
+	if _, err := io.Copy(output, io.TeeReader(input, hash)); err != nil {
+		output.Close()
+		return err
+	}
+	if syncCopies {
+		if err := output.Sync(); err != nil {
+			output.Close()
+			return err
+		}
+	}
+	if err := output.Chmod(0644); err != nil {
+		output.Close()
+		return err
+	}
+	if err := output.Close(); err != nil {
+		return err
+	}
+
+	want := hex.EncodeToString(hash.Sum(nil))
+	got, err := hashFile(output.Name())
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch copying %s: read %s, wrote %s", src, want, got)
+	}
+
 	// Overwrite the file if it exists
-	output, err := os.Create(dst)
+	if err := os.Rename(output.Name(), dst); err != nil {
+		return err
+	}
+	if syncCopies {
+		return syncDir(filepath.Dir(dst))
+	}
+	return nil
+}
+
+// syncDir flushes the entries of dir, such as a rename into it, to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	defer output.Close()
-
-	_, err = io.Copy(output, input)
-	return err
-}
-
-func servePage(w http.ResponseWriter, r *http.Request) {
-	logger.Printf("request from %s: %s %s", r.RemoteAddr, r.Method, r.URL.Path)
-	htmlContent := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <title>Image of the Day</title>
-    <style>
-        body {
-            background-color: #121212;
-            color: #ffffff;
-            font-family: Arial, sans-serif;
-            text-align: center;
-            margin: 0;
-            padding: 0;
-            overflow: hidden;
-        }
-        h1 {
-            margin-top: 20px;
-        }
-        p {
-            margin-bottom: 20px;
-        }
-        img {
-            max-width: 100%;
-            max-height: calc(100vh - 140px);
-            border-radius: 15px;
-        }
-    </style>
-</head>
-<body>
-    <h1>Monkey Image of the Day</h1>
-    <p>Enjoy a new one every day!</p>
-	<img src="/assets/` + assetImageFilename + `" alt="Image of the Day">
-</body>
-</html>
-`
-	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprint(w, htmlContent)
-}
-
-//
-// ImageMapper implementation
-//
-
-type ImageMapper struct {
-	images []string
-}
-
-// NewImageMapper creates a new ImageMapper with a list of image names.
-// The images should be sorted to ensure consistent ordering.
-func NewImageMapper(images []string) *ImageMapper {
-	// Make a copy of the images slice to prevent external modifications.
-	imgs := make([]string, len(images))
-	copy(imgs, images)
-	// Sort the images to ensure consistent ordering.
-	sort.Strings(imgs)
-	return &ImageMapper{images: imgs}
-}
-
-// GetImageForDate returns the image name for a given date.
-func (im *ImageMapper) GetImageForDate(date time.Time) (string, error) {
-	if len(im.images) == 0 {
-		return "", errors.New("image list is empty")
-	}
-
-	// Ensure the date is not in the future.
-	today := time.Now().In(location)
-	if date.After(today) {
-		return "", errors.New("date is in the future")
-	}
-
-	// Ensure the date is not before the epoch (Jan 1, 2000).
-	epoch := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
-	if date.Before(epoch) {
-		return "", errors.New("date is before the supported range (Jan 1, 2000)")
-	}
-
-	// Convert the date to a string in a consistent format.
-	dateStr := date.Format("2006-01-02")
-	dateHash := sha256.Sum256([]byte(dateStr))
-
-	var maxScore uint64
-	var selectedImage string
-
-	for _, img := range im.images {
-		// Combine the date hash with the image name.
-		combined := append(dateHash[:], []byte(img)...)
-		hash := sha256.Sum256(combined)
-
-		// Convert the first 8 bytes of the hash to a uint64 for scoring.
-		score := binary.BigEndian.Uint64(hash[:8])
-
-		// Select the image with the highest score.
-		if score > maxScore || selectedImage == "" {
-			maxScore = score
-			selectedImage = img
-		}
-	}
-
-	return selectedImage, nil
+	defer d.Close()
+	return d.Sync()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		errorLogger.Printf("Error encoding JSON response: %v", err)
+	}
 }
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// setupTest sets the settings the tests depend on to their defaults, with
+// the timezone UTC, the asset directory a temporary one and logging
+// discarded, and restores the previous values afterwards.
+func setupTest(t testing.TB) {
+	t.Helper()
+	oldLocation, oldAssetDir, oldStrategy, oldInterval := location, assetDir, strategy, interval
+	oldLoggers := []*log.Logger{logger, debugLogger, warnLogger, errorLogger, accessLogger}
+	t.Cleanup(func() {
+		location, assetDir, strategy, interval = oldLocation, oldAssetDir, oldStrategy, oldInterval
+		logger, debugLogger, warnLogger, errorLogger, accessLogger = oldLoggers[0], oldLoggers[1], oldLoggers[2], oldLoggers[3], oldLoggers[4]
+		forgetScans()
+	})
+	location = time.UTC
+	assetDir = t.TempDir()
+	strategy = "hash"
+	interval = 0
+	setLoggers(io.Discard, "plain", slog.LevelDebug)
+}
+
+func TestCopyFile(t *testing.T) {
+	content := []byte("not really a jpeg, but copyFile doesn't care")
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, dir string) (src, dst string)
+		wantErr bool
+		// want is what dst must contain afterwards, or nil if it must not exist
+		want []byte
+	}{
+		{
+			name: "copy",
+			setup: func(t *testing.T, dir string) (string, string) {
+				return writeTestFile(t, dir, "src.jpg", content), filepath.Join(dir, "dst.jpg")
+			},
+			want: content,
+		},
+		{
+			name: "overwrite",
+			setup: func(t *testing.T, dir string) (string, string) {
+				return writeTestFile(t, dir, "src.jpg", content), writeTestFile(t, dir, "dst.jpg", []byte("yesterday"))
+			},
+			want: content,
+		},
+		{
+			name: "empty source",
+			setup: func(t *testing.T, dir string) (string, string) {
+				return writeTestFile(t, dir, "src.jpg", nil), filepath.Join(dir, "dst.jpg")
+			},
+			want: []byte{},
+		},
+		{
+			name: "missing source",
+			setup: func(t *testing.T, dir string) (string, string) {
+				return filepath.Join(dir, "missing.jpg"), filepath.Join(dir, "dst.jpg")
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing destination directory",
+			setup: func(t *testing.T, dir string) (string, string) {
+				return writeTestFile(t, dir, "src.jpg", content), filepath.Join(dir, "missing", "dst.jpg")
+			},
+			wantErr: true,
+		},
+		{
+			// Opening a directory works, but reading it fails midway
+			// through the copy
+			name: "failed read keeps destination",
+			setup: func(t *testing.T, dir string) (string, string) {
+				src := filepath.Join(dir, "src")
+				if err := os.Mkdir(src, 0755); err != nil {
+					t.Fatal(err)
+				}
+				return src, writeTestFile(t, dir, "dst.jpg", []byte("yesterday"))
+			},
+			wantErr: true,
+			want:    []byte("yesterday"),
+		},
+		{
+			name: "failed rename keeps destination",
+			setup: func(t *testing.T, dir string) (string, string) {
+				dst := filepath.Join(dir, "dst.jpg")
+				writeTestFile(t, dst, "keep", []byte("yesterday"))
+				return writeTestFile(t, dir, "src.jpg", content), dst
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, sync := range []bool{false, true} {
+		for _, tt := range tests {
+			name := tt.name
+			if sync {
+				name += " with fsync"
+			}
+			t.Run(name, func(t *testing.T) {
+				defer func(old bool) { syncCopies = old }(syncCopies)
+				syncCopies = sync
+
+				dir := t.TempDir()
+				src, dst := tt.setup(t, dir)
+				err := copyFile(src, dst)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("copyFile() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if got, err := os.ReadFile(dst); tt.want != nil {
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, tt.want) {
+						t.Errorf("dst = %q, want %q", got, tt.want)
+					}
+				} else if err == nil && tt.wantErr {
+					t.Errorf("dst = %q, want no file", got)
+				}
+
+				// No temporary file may be left behind, whether the
+				// copy succeeded or not
+				temps, _ := filepath.Glob(filepath.Join(filepath.Dir(dst), ".copy-*"))
+				if len(temps) > 0 {
+					t.Errorf("temporary files left behind: %v", temps)
+				}
+			})
+		}
+	}
+}
+
+// writeTestFile writes content to name in dir, creating dir if needed, and
+// returns its path.
+func writeTestFile(t testing.TB, dir, name string, content []byte) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestJPEG writes a small JPEG to path, creating its directory.
+func writeTestJPEG(t testing.TB, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := jpeg.Encode(file, image.NewGray(image.Rect(0, 0, 4, 3)), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextRenewalAcrossDST(t *testing.T) {
+	tests := []struct {
+		zone string
+		note string
+	}{
+		{"Europe/Berlin", "changes at 02:00 and 03:00"},
+		{"America/New_York", "changes at 02:00"},
+		{"America/Santiago", "skips midnight in September, repeats 23:00 in April"},
+		{"America/Havana", "skips midnight in March"},
+		{"Asia/Beirut", "skips midnight in March, repeats 23:00 in October"},
+		{"Australia/Lord_Howe", "shifts by half an hour"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			setupTest(t)
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			location = loc
+
+			// Fire the scheduler for every day of a year, as if the timer
+			// expired exactly on time
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, loc)
+			for i := 0; i < 366; i++ {
+				next := nextRenewal(now)
+				wantDay := time.Date(now.Year(), now.Month(), now.Day()+1, 12, 0, 0, 0, loc)
+				if got := next.In(loc); got.YearDay() != wantDay.YearDay() || got.Year() != wantDay.Year() {
+					t.Fatalf("nextRenewal(%v) = %v, want a time on %s (%s)", now, got, wantDay.Format("2006-01-02"), tt.note)
+				}
+				// The first instant of the day: a nanosecond earlier it's
+				// still the day before
+				if before := next.Add(-time.Nanosecond).In(loc); before.Day() == next.In(loc).Day() {
+					t.Fatalf("nextRenewal(%v) = %v, which isn't local midnight (%s)", now, next, tt.note)
+				}
+				// A timer firing a little early still waits for the same
+				// midnight rather than skipping a day
+				if early := nextRenewal(next.Add(-time.Millisecond)); !early.Equal(next) {
+					t.Fatalf("nextRenewal(%v) = %v, want %v (%s)", next.Add(-time.Millisecond), early, next, tt.note)
+				}
+				now = next
+			}
+		})
+	}
+}
+
+func TestPeriodLabel(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	// 20:30 UTC is 05:30 the next day in Tokyo
+	evening := time.Date(2024, 1, 15, 20, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		location *time.Location
+		interval time.Duration
+		t        time.Time
+		want     string
+	}{
+		{"day", time.UTC, 0, evening, "2024-01-15"},
+		{"day in timezone", tokyo, 0, evening, "2024-01-16"},
+		{"day in timezone from local", tokyo, 0, evening.Local(), "2024-01-16"},
+		{"hour", time.UTC, time.Hour, evening, "2024-01-15T2000"},
+		{"hours in timezone", tokyo, 6 * time.Hour, evening, "2024-01-16T0000"},
+		{"minutes", tokyo, 90 * time.Minute, evening, "2024-01-16T0430"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			location, interval = tt.location, tt.interval
+			if got := periodLabel(tt.t); got != tt.want {
+				t.Errorf("periodLabel(%v) = %q, want %q", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	setupTest(t)
+	// Its date differs from UTC's for 14 hours of every day
+	location = time.FixedZone("LINT", 14*60*60)
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		img  installedImage
+		want bool
+	}{
+		{"none", installedImage{}, false},
+		{"selected now", installedImage{filename: "today.jpg", time: now.In(location)}, false},
+		{"selected now on the host", installedImage{filename: "today.jpg", time: now}, false},
+		{"selected yesterday", installedImage{filename: "today.jpg", time: now.In(location).AddDate(0, 0, -1)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.img.isStale(); got != tt.want {
+				t.Errorf("isStale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePools(t *testing.T) {
+	tests := []struct {
+		config  string
+		want    []poolWeight
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"premium=3", []poolWeight{{"premium", 3}}, false},
+		{"regular=1, premium=0.5", []poolWeight{{"premium", 0.5}, {"regular", 1}}, false},
+		{"premium", nil, true},
+		{"=3", nil, true},
+		{"a/b=1", nil, true},
+		{"premium=0", nil, true},
+		{"premium=-1", nil, true},
+		{"premium=lots", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			got, err := parsePools(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePools(%q) error = %v, wantErr %v", tt.config, err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parsePools(%q) = %v, want %v", tt.config, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanImagesNested(t *testing.T) {
+	files := []string{"sunset.jpg", "a/sunset.jpg", "b/sunset.jpg", "b/c/moon.jpg", "b/notes.txt"}
+
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		nested  bool // Whether the asset directory is inside the image directory
+		want    []string
+	}{
+		{"all", "", "", false, []string{"a/sunset.jpg", "b/c/moon.jpg", "b/sunset.jpg", "sunset.jpg"}},
+		{"include by base name", "sunset*", "", false, []string{"a/sunset.jpg", "b/sunset.jpg", "sunset.jpg"}},
+		{"exclude by base name", "", "moon.jpg", false, []string{"a/sunset.jpg", "b/sunset.jpg", "sunset.jpg"}},
+		{"asset directory skipped", "", "", true, []string{"a/sunset.jpg", "b/c/moon.jpg", "b/sunset.jpg", "sunset.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			defer func(dir, include, exclude string) { imageDir, includeGlob, excludeGlob = dir, include, exclude }(imageDir, includeGlob, excludeGlob)
+			imageDir = t.TempDir()
+			includeGlob, excludeGlob = tt.include, tt.exclude
+			for _, name := range files {
+				writeTestJPEG(t, filepath.Join(imageDir, filepath.FromSlash(name)))
+			}
+			if tt.nested {
+				assetDir = filepath.Join(imageDir, "assets")
+				writeTestJPEG(t, filepath.Join(assetDir, "today.jpg"))
+			}
+
+			images, err := scanImages(imageDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := imageNames(images)
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("scanImages() = %v, want %v", got, tt.want)
+			}
+			// Every name leads back to its own file
+			for _, name := range got {
+				path, err := sourcePath(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := filepath.Join(imageDir, filepath.FromSlash(name)); path != want {
+					t.Errorf("sourcePath(%q) = %q, want %q", name, path, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSameNamedImagesSelectable(t *testing.T) {
+	setupTest(t)
+	defer func(dir string) { imageDir = dir }(imageDir)
+	imageDir = t.TempDir()
+	names := []string{"a/sunset.jpg", "b/sunset.jpg", "c/sunset.jpg"}
+	for _, name := range names {
+		writeTestJPEG(t, filepath.Join(imageDir, filepath.FromSlash(name)))
+	}
+
+	images, err := loadImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper := newMapper(images)
+	picked := make(map[string]bool)
+	for day := 0; day < 365; day++ {
+		selected, err := mapper.GetImageForDate(mustDate(t, "2024-01-01").AddDate(0, 0, day))
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked[selected] = true
+	}
+	for _, name := range names {
+		if !picked[name] {
+			t.Errorf("%s was never selected in a year", name)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// manifest records which image is shown on which date, signed with an
+// HMAC-SHA256 over the JSON encoding of its entries.
+type manifest struct {
+	Entries   []manifestEntry `json:"entries"`
+	Signature string          `json:"signature"`
+}
+
+type manifestEntry struct {
+	Date     string `json:"date"`
+	Filename string `json:"filename"`
+	SHA256   string `json:"sha256"`
+}
+
+// sign returns the hex HMAC of the manifest entries under key.
+func (m *manifest) sign(key []byte) (string, error) {
+	payload, err := json.Marshal(m.Entries)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// runManifest prints a signed manifest of the selections in a date range.
+func runManifest(args []string) {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	registerFlags(fs)
+	var from, to, key, out string
+	fs.StringVar(&out, "out", "", "File to write the manifest to (default stdout)")
+	fs.StringVar(&from, "from", "", "First date of the manifest, e.g. 2024-01-01 (default today)")
+	fs.StringVar(&to, "to", "", "Last date of the manifest (default 30 days after -from)")
+	fs.StringVar(&key, "key", getEnv("MANIFEST_KEY", ""), "Key for signing the manifest")
+	fs.Parse(args)
+
+	closeLog := setup()
+	defer closeLog()
+
+	if key == "" {
+		errorLogger.Fatalf("A signing key is required (-key or MANIFEST_KEY)")
+	}
+	start, end, err := parseDateRange(from, to, 30)
+	if err != nil {
+		errorLogger.Fatalf("%v", err)
+	}
+
+	images, err := loadImages()
+	if err != nil {
+		errorLogger.Fatalf("Error getting image list: %v", err)
+	}
+	mapper := newMapper(images)
+
+	var m manifest
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		selectedImage, err := previewImage(mapper, date, region)
+		if err != nil {
+			errorLogger.Fatalf("Error selecting image for %s: %v", date.Format("2006-01-02"), err)
+		}
+		path, err := sourcePath(selectedImage)
+		if err != nil {
+			errorLogger.Fatalf("Error fetching %s: %v", selectedImage, err)
+		}
+		sum, err := hashFile(path)
+		if err != nil {
+			errorLogger.Fatalf("Error hashing %s: %v", selectedImage, err)
+		}
+		m.Entries = append(m.Entries, manifestEntry{Date: date.Format("2006-01-02"), Filename: selectedImage, SHA256: sum})
+	}
+	if m.Signature, err = m.sign([]byte(key)); err != nil {
+		errorLogger.Fatalf("Error signing manifest: %v", err)
+	}
+
+	output := os.Stdout
+	if out != "" {
+		if output, err = os.Create(out); err != nil {
+			errorLogger.Fatalf("Error creating manifest: %v", err)
+		}
+		defer output.Close()
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		errorLogger.Fatalf("Error writing manifest: %v", err)
+	}
+}
+
+// runVerify checks the signature of a manifest and that the images it
+// references still match their hashes.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	registerFlags(fs)
+	var key string
+	fs.StringVar(&key, "key", getEnv("MANIFEST_KEY", ""), "Key the manifest was signed with")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify [flags] manifest.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	closeLog := setup()
+	defer closeLog()
+
+	if key == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		errorLogger.Fatalf("Error reading manifest: %v", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		errorLogger.Fatalf("Error parsing manifest: %v", err)
+	}
+
+	signature, err := m.sign([]byte(key))
+	if err != nil || !hmac.Equal([]byte(signature), []byte(m.Signature)) {
+		errorLogger.Fatalf("Manifest signature is invalid")
+	}
+
+	// The index or URL list maps filenames to the images to download
+	if remoteImages() {
+		if _, err := loadIndex(); err != nil {
+			errorLogger.Fatalf("Error fetching image index: %v", err)
+		}
+	}
+
+	failed := 0
+	for _, entry := range m.Entries {
+		path, err := sourcePath(entry.Filename)
+		var sum string
+		if err == nil {
+			sum, err = hashFile(path)
+		}
+		switch {
+		case err != nil:
+			warnLogger.Printf("%s: %s can't be read: %v", entry.Date, entry.Filename, err)
+			failed++
+		case sum != entry.SHA256:
+			warnLogger.Printf("%s: %s has changed", entry.Date, entry.Filename)
+			failed++
+		}
+	}
+	if failed > 0 {
+		errorLogger.Fatalf("Manifest verification failed for %d of %d entries", failed, len(m.Entries))
+	}
+	logger.Printf("Manifest verified: %d entries", len(m.Entries))
+}
+
+// parseDateRange parses the dates from and to in the specified timezone. An
+// empty from means today, an empty to means days after from.
+func parseDateRange(from, to string, days int) (time.Time, time.Time, error) {
+	now := time.Now().In(location)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	if from != "" {
+		var err error
+		if start, err = time.ParseInLocation("2006-01-02", from, location); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -from date: %w", err)
+		}
+	}
+	end := start.AddDate(0, 0, days)
+	if to != "" {
+		var err error
+		if end, err = time.ParseInLocation("2006-01-02", to, location); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -to date: %w", err)
+		}
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, errors.New("-to must not be before -from")
+	}
+	return start, end, nil
+}
+
+// hashFile returns the hex sha256 of the file at path.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
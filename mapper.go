@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ImageMapper implementation
+//
+
+// epoch is the first date the ImageMapper supports.
+var epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Errors for dates outside the supported range.
+var (
+	ErrFutureDate  = errors.New("date is in the future")
+	ErrBeforeEpoch = errors.New("date is before the supported range (Jan 1, 2000)")
+)
+
+type ImageMapper struct {
+	images   []string
+	pools    []Pool                // Optional weighted pools, sorted by name
+	strategy Strategy              // Decides the image of each period, HashStrategy if nil
+	weights  map[string]float64    // Optional relative weights, 1 if absent
+	seed     string                // Optional salt prepended to every hash input
+	now      func() time.Time      // Clock for rejecting future dates, time.Now if nil
+	interval time.Duration         // Optional length of a selection period, a day if 0
+	windows  map[string]dateWindow // Optional dates images are limited to, see WithWindows
+
+	// Blocks of chained picks used by GetImageForDateExcludingRecent,
+	// computed lazily for recentWindow; see recentChain.
+	recentMutex  sync.Mutex
+	recentWindow int
+	recentPicks  map[recentKey][]string
+
+	// Mappers of the images eligible on some date, by their joined names,
+	// so their chains of picks are computed once.
+	viewMutex sync.Mutex
+	views     map[string]*ImageMapper
+}
+
+// recentKey identifies a block of chained picks of a sequence.
+type recentKey struct {
+	block int
+	key   string
+}
+
+// NewImageMapper creates a new ImageMapper with a list of image names.
+// The images should be sorted to ensure consistent ordering.
+func NewImageMapper(images []string) *ImageMapper {
+	// Make a copy of the images slice to prevent external modifications.
+	imgs := make([]string, len(images))
+	copy(imgs, images)
+	// Sort the images to ensure consistent ordering.
+	sort.Strings(imgs)
+	return &ImageMapper{images: imgs}
+}
+
+// Pool is a named set of images that is chosen with a relative weight.
+type Pool struct {
+	Name   string
+	Weight float64
+	Images []string
+}
+
+// NewPooledImageMapper creates an ImageMapper that, for each date, first
+// picks one of pools with a probability proportional to its weight, then an
+// image within that pool. Both steps are deterministic for a given date.
+func NewPooledImageMapper(pools []Pool) *ImageMapper {
+	var all []string
+	sorted := make([]Pool, 0, len(pools))
+	for _, pool := range pools {
+		if pool.Weight <= 0 || len(pool.Images) == 0 {
+			continue
+		}
+		imgs := make([]string, len(pool.Images))
+		copy(imgs, pool.Images)
+		sort.Strings(imgs)
+		sorted = append(sorted, Pool{Name: pool.Name, Weight: pool.Weight, Images: imgs})
+		all = append(all, imgs...)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	im := NewImageMapper(all)
+	im.pools = sorted
+	return im
+}
+
+// NewPlaylistImageMapper creates an ImageMapper that shows the images of
+// playlist in order, one per day, starting over after the last one. The
+// playlist should only name existing images; see compactPlaylist.
+func NewPlaylistImageMapper(playlist []string) *ImageMapper {
+	seen := make(map[string]bool, len(playlist))
+	var unique []string
+	for _, img := range playlist {
+		if !seen[img] {
+			seen[img] = true
+			unique = append(unique, img)
+		}
+	}
+	im := NewImageMapper(unique)
+	im.strategy = playlistStrategy{entries: slices.Clone(playlist)}
+	return im
+}
+
+// WithWeights makes images with a higher weight win proportionally more
+// dates, using weighted rendezvous hashing. Images without a weight count as
+// 1. It returns im for chaining.
+func (im *ImageMapper) WithWeights(weights map[string]float64) *ImageMapper {
+	im.weights = weights
+	return im
+}
+
+// WithSeed mixes seed into every selection, so mappers with the same images
+// and seed agree, while a different seed reshuffles all dates. The empty
+// seed keeps the unseeded selection. It returns im for chaining.
+func (im *ImageMapper) WithSeed(seed string) *ImageMapper {
+	im.seed = seed
+	return im
+}
+
+// WithStrategy makes im select images with strategy instead of
+// HashStrategy. It returns im for chaining.
+func (im *ImageMapper) WithStrategy(strategy Strategy) *ImageMapper {
+	im.strategy = strategy
+	return im
+}
+
+// WithWindows limits images to the dates in their window: each date is
+// selected among the images whose window includes it and those without
+// one, or if there are none, among all images. Playlists ignore windows.
+// It returns im for chaining.
+func (im *ImageMapper) WithWindows(windows map[string]dateWindow) *ImageMapper {
+	im.windows = windows
+	return im
+}
+
+// WithClock makes im use now instead of time.Now to tell whether a date is
+// in the future, e.g. to pin the current time. It returns im for chaining.
+func (im *ImageMapper) WithClock(now func() time.Time) *ImageMapper {
+	im.now = now
+	return im
+}
+
+// Contains reports whether name is part of the image list.
+func (im *ImageMapper) Contains(name string) bool {
+	i := sort.SearchStrings(im.images, name)
+	return i < len(im.images) && im.images[i] == name
+}
+
+// WithInterval selects a new image every interval instead of every day.
+// Periods start at midnight, so interval must divide a day evenly into
+// whole minutes. It returns im for chaining.
+func (im *ImageMapper) WithInterval(interval time.Duration) *ImageMapper {
+	im.interval = interval
+	return im
+}
+
+// Images returns the sorted names of the images im selects from.
+func (im *ImageMapper) Images() []string {
+	return slices.Clone(im.images)
+}
+
+// GetImageForDate returns the image name for a given date. The day is taken
+// from date in its own location, so callers convert it to the timezone the
+// image changes in first; 23:59 and 00:01 there fall on different days.
+func (im *ImageMapper) GetImageForDate(date time.Time) (string, error) {
+	return im.GetImageForDateWithKey(date, "")
+}
+
+// GetImageForDateWithKey returns the image name for a given date within the
+// sequence identified by key. Different keys yield independent, but equally
+// deterministic, sequences. The empty key is the default sequence.
+func (im *ImageMapper) GetImageForDateWithKey(date time.Time, key string) (string, error) {
+	return im.imageForDate(date, key, 0, false)
+}
+
+// GetImageForWeek returns the image name for the ISO week containing date.
+// It's keyed on the ISO year and week, so it's stable from Monday to Sunday
+// and independent of the daily selection.
+func (im *ImageMapper) GetImageForWeek(date time.Time) (string, error) {
+	if err := im.checkDate(date, false); err != nil {
+		return "", err
+	}
+	return im.pick(isoWeek(date), nil), nil
+}
+
+// isoWeek formats the ISO week of date, e.g. 2024-W03.
+func isoWeek(date time.Time) string {
+	year, week := date.ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// GetImageForDateExcludingRecent returns the image name for a given date and
+// key, skipping the images picked on the n days before it. The picks form
+// chains of a fixed length, see recentChain, so a date always yields the
+// same image no matter in which order dates are requested, and the cost of
+// a lookup doesn't grow with the date. If the pool is too small to exclude n
+// images, the window shrinks to leave at least one candidate.
+func (im *ImageMapper) GetImageForDateExcludingRecent(date time.Time, key string, n int) (string, error) {
+	return im.imageForDate(date, key, n, false)
+}
+
+// imageForDate implements the public lookups by delegating to the strategy
+// of im. With allowFuture set, dates after today are accepted, which is only
+// meant for previews.
+func (im *ImageMapper) imageForDate(date time.Time, key string, n int, allowFuture bool) (string, error) {
+	if err := im.checkDate(date, allowFuture); err != nil {
+		return "", err
+	}
+	if _, ok := im.eligible(date); !ok {
+		warnLogger.Printf("No image is scheduled for %s, selecting among all images", date.Format("2006-01-02"))
+	}
+
+	strategy := im.strategy
+	if strategy == nil {
+		strategy = HashStrategy{}
+	}
+	return strategy.Select(im, im.period(date), key, n), nil
+}
+
+// eligibleIn returns a mapper like im that only selects among the images
+// whose window includes the day of the period numbered period; see
+// eligible. Strategies select among these.
+func (im *ImageMapper) eligibleIn(period int) *ImageMapper {
+	view, _ := im.eligible(epoch.AddDate(0, 0, period/im.periodsPerDay()))
+	return view
+}
+
+// eligible returns a mapper like im that only selects among the images
+// whose window includes the day of date, or im itself if they all do. If
+// none does, it returns im and false.
+func (im *ImageMapper) eligible(date time.Time) (*ImageMapper, bool) {
+	if len(im.windows) == 0 {
+		return im, true
+	}
+	var images []string
+	eligible := make(map[string]bool)
+	for _, img := range im.images {
+		if window, ok := im.windows[img]; !ok || window.contains(date) {
+			images = append(images, img)
+			eligible[img] = true
+		}
+	}
+	if len(images) == len(im.images) {
+		return im, true
+	}
+	if len(images) == 0 {
+		return im, false
+	}
+
+	im.viewMutex.Lock()
+	defer im.viewMutex.Unlock()
+	viewKey := strings.Join(images, "\x00")
+	if view, ok := im.views[viewKey]; ok {
+		return view, true
+	}
+	view := &ImageMapper{images: images, strategy: im.strategy, weights: im.weights, seed: im.seed, now: im.now, interval: im.interval}
+	for _, pool := range im.pools {
+		var poolImages []string
+		for _, img := range pool.Images {
+			if eligible[img] {
+				poolImages = append(poolImages, img)
+			}
+		}
+		if len(poolImages) > 0 {
+			view.pools = append(view.pools, Pool{Name: pool.Name, Weight: pool.Weight, Images: poolImages})
+		}
+	}
+	if im.views == nil {
+		im.views = make(map[string]*ImageMapper)
+	}
+	im.views[viewKey] = view
+	return view, true
+}
+
+// periodsPerDay returns the number of selection periods in a day.
+func (im *ImageMapper) periodsPerDay() int {
+	if im.interval <= 0 {
+		return 1
+	}
+	return int(24 * time.Hour / im.interval)
+}
+
+// period returns the number of selection periods between the epoch and the
+// one containing date, by the wall clock of date's location. Without an
+// interval, that's the day number.
+func (im *ImageMapper) period(date time.Time) int {
+	n := dayNumber(date) * im.periodsPerDay()
+	if im.interval > 0 {
+		sinceMidnight := time.Duration(date.Hour())*time.Hour + time.Duration(date.Minute())*time.Minute + time.Duration(date.Second())*time.Second
+		n += int(sinceMidnight / im.interval)
+	}
+	return n
+}
+
+// periodKey formats the period numbered i for hashing: its date, e.g.
+// 2024-01-15, followed with an interval by its start time, e.g.
+// 2024-01-15T06:00.
+func (im *ImageMapper) periodKey(i int) string {
+	perDay := im.periodsPerDay()
+	key := epoch.AddDate(0, 0, i/perDay).Format("2006-01-02")
+	if im.interval > 0 {
+		key += "T" + epoch.Add(time.Duration(i%perDay)*im.interval).Format("15:04")
+	}
+	return key
+}
+
+// dayNumber returns the number of days between the epoch and date.
+func dayNumber(date time.Time) int {
+	return int(time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).Sub(epoch).Hours() / 24)
+}
+
+// hashInput combines a formatted date with a sequence key, labeled as
+// "key:<length>:<key>:" so that no key can be mistaken for a seed. The empty
+// key leaves the date untouched.
+func hashInput(dateStr, key string) string {
+	if key == "" {
+		return dateStr
+	}
+	return labeled("key", key) + dateStr
+}
+
+// labeled returns value prefixed with label and its length in bytes, as
+// "<label>:<length>:<value>:". Values labeled differently, or containing
+// colons, thus never run together into the same hash input.
+func labeled(label, value string) string {
+	return label + ":" + strconv.Itoa(len(value)) + ":" + value + ":"
+}
+
+// checkDate validates that date lies within the supported range. Dates are
+// in the future if they're after the current time of im's clock, regardless
+// of location.
+func (im *ImageMapper) checkDate(date time.Time, allowFuture bool) error {
+	if len(im.images) == 0 {
+		return errors.New("image list is empty")
+	}
+
+	// Ensure the date is not in the future.
+	now := time.Now
+	if im.now != nil {
+		now = im.now
+	}
+	if !allowFuture && date.After(now()) {
+		return ErrFutureDate
+	}
+
+	// Ensure the date is not before the epoch (Jan 1, 2000).
+	if date.Before(epoch) {
+		return ErrBeforeEpoch
+	}
+	return nil
+}
+
+// pick returns the highest scoring image for dateStr that is not excluded.
+// With pools, the image is picked from the pool chosen for dateStr, falling
+// back to all images if everything in that pool is excluded. A seed is
+// prepended to dateStr as "seed:<length>:<seed>:".
+func (im *ImageMapper) pick(dateStr string, exclude map[string]bool) string {
+	if im.seed != "" {
+		dateStr = labeled("seed", im.seed) + dateStr
+	}
+	if len(im.pools) > 0 {
+		if img := pickFrom(im.pickPool(dateStr).Images, dateStr, exclude, im.weights); img != "" {
+			return img
+		}
+	}
+	return pickFrom(im.images, dateStr, exclude, im.weights)
+}
+
+// pickPool chooses a pool for dateStr with a probability proportional to
+// its weight.
+func (im *ImageMapper) pickPool(dateStr string) Pool {
+	var total float64
+	for _, pool := range im.pools {
+		total += pool.Weight
+	}
+
+	// Map the hash to a uniformly distributed point in [0, total).
+	hash := sha256.Sum256([]byte("pool:" + dateStr))
+	point := float64(binary.BigEndian.Uint64(hash[:8])>>11) / (1 << 53) * total
+
+	for _, pool := range im.pools {
+		if point < pool.Weight {
+			return pool
+		}
+		point -= pool.Weight
+	}
+	return im.pools[len(im.pools)-1]
+}
+
+// pickFrom returns the highest scoring image of images for dateStr that is
+// not excluded, or "" if there is none. Equal scores are broken by taking the
+// lexicographically smallest name, so the result doesn't depend on the order
+// of images. With weights, the scores are weighted; see pickWeighted.
+func pickFrom(images []string, dateStr string, exclude map[string]bool, weights map[string]float64) string {
+	dateHash := sha256.Sum256([]byte(dateStr))
+	if len(weights) > 0 {
+		return pickWeighted(images, dateHash, exclude, weights)
+	}
+
+	var maxScore uint64
+	var selectedImage string
+	found := false
+
+	for _, img := range images {
+		if exclude[img] {
+			continue
+		}
+
+		score := scoreImage(dateHash, img)
+
+		// Select the image with the highest score, or the smaller name on a tie.
+		if !found || score > maxScore || (score == maxScore && img < selectedImage) {
+			maxScore = score
+			selectedImage = img
+			found = true
+		}
+	}
+
+	return selectedImage
+}
+
+// pickWeighted is pickFrom for weighted images. Each score is mapped to a
+// uniform u in (0, 1) and weighted as -weight/ln(u), so an image wins with a
+// probability proportional to its weight.
+func pickWeighted(images []string, dateHash [sha256.Size]byte, exclude map[string]bool, weights map[string]float64) string {
+	var maxScore float64
+	var selectedImage string
+	found := false
+
+	for _, img := range images {
+		if exclude[img] {
+			continue
+		}
+
+		weight, ok := weights[img]
+		if !ok {
+			weight = 1
+		}
+		u := (float64(scoreImage(dateHash, img)>>11) + 0.5) / (1 << 53)
+		score := -weight / math.Log(u)
+
+		if !found || score > maxScore || (score == maxScore && img < selectedImage) {
+			maxScore = score
+			selectedImage = img
+			found = true
+		}
+	}
+
+	return selectedImage
+}
+
+// scoreImage returns the score of img for the date hash: the first 8 bytes,
+// big-endian, of the SHA-256 of the date hash followed by the image name.
+func scoreImage(dateHash [sha256.Size]byte, img string) uint64 {
+	// Combine the date hash with the image name.
+	combined := append(dateHash[:], []byte(img)...)
+	hash := sha256.Sum256(combined)
+
+	// Convert the first 8 bytes of the hash to a uint64 for scoring.
+	return binary.BigEndian.Uint64(hash[:8])
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustDate parses a date as 2006-01-02 in UTC.
+func mustDate(t *testing.T, value string) time.Time {
+	t.Helper()
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return date
+}
+
+func TestGetImageForDate(t *testing.T) {
+	three := []string{"a.jpg", "b.jpg", "c.jpg"}
+	monkeys := []string{"monkey1.jpg", "monkey2.jpg", "monkey3.jpg", "monkey4.jpg", "monkey5.jpg"}
+
+	tests := []struct {
+		name   string
+		images []string
+		date   string
+		want   string
+	}{
+		{"single image", []string{"a.jpg"}, "2024-01-15", "a.jpg"},
+		{"epoch", three, "2000-01-01", "c.jpg"},
+		{"three images", three, "2024-01-15", "a.jpg"},
+		{"leap day", three, "2024-02-29", "a.jpg"},
+		{"five images", monkeys, "2024-01-15", "monkey5.jpg"},
+		{"five images leap day", monkeys, "2024-02-29", "monkey4.jpg"},
+		{"unsorted input", []string{"monkey4.jpg", "monkey2.jpg", "monkey5.jpg", "monkey1.jpg", "monkey3.jpg"}, "2024-02-29", "monkey4.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewImageMapper(tt.images).GetImageForDate(mustDate(t, tt.date))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("GetImageForDate(%s) = %q, want %q", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetImageForDateErrors(t *testing.T) {
+	now := mustDate(t, "2024-01-15")
+	tests := []struct {
+		name   string
+		images []string
+		date   time.Time
+		want   error
+	}{
+		{"before epoch", []string{"a.jpg"}, mustDate(t, "1999-12-31"), ErrBeforeEpoch},
+		{"future", []string{"a.jpg"}, now.AddDate(0, 0, 1), ErrFutureDate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewImageMapper(tt.images).WithClock(func() time.Time { return now }).GetImageForDate(tt.date)
+			if err != tt.want {
+				t.Errorf("GetImageForDate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewImageMapper(nil).GetImageForDate(now); err == nil {
+		t.Error("GetImageForDate() of no images succeeded")
+	}
+}
+
+func TestPickFromIgnoresOrder(t *testing.T) {
+	images := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg", "f.jpg"}
+	reversed := slices.Clone(images)
+	slices.Reverse(reversed)
+	for day := 0; day < 365; day++ {
+		dateStr := epoch.AddDate(24, 0, day).Format("2006-01-02")
+		if a, b := pickFrom(images, dateStr, nil, nil), pickFrom(reversed, dateStr, nil, nil); a != b {
+			t.Fatalf("%s: picked %q in sorted order, %q in reverse order", dateStr, a, b)
+		}
+	}
+}
+
+func TestPickFromTie(t *testing.T) {
+	// An image listed twice ties with itself, which mustn't change the pick
+	images := []string{"b.jpg", "a.jpg", "b.jpg"}
+	for day := 0; day < 30; day++ {
+		dateStr := epoch.AddDate(0, 0, day).Format("2006-01-02")
+		want := pickFrom([]string{"a.jpg", "b.jpg"}, dateStr, nil, nil)
+		if got := pickFrom(images, dateStr, nil, nil); got != want {
+			t.Errorf("%s: picked %q, want %q", dateStr, got, want)
+		}
+	}
+}
+
+func TestPickFromExclude(t *testing.T) {
+	images := []string{"a.jpg", "b.jpg", "c.jpg"}
+	tests := []struct {
+		name    string
+		exclude map[string]bool
+		want    string
+	}{
+		{"none excluded", nil, "a.jpg"},
+		{"winner excluded", map[string]bool{"a.jpg": true}, pickFrom([]string{"b.jpg", "c.jpg"}, "2024-01-15", nil, nil)},
+		{"all excluded", map[string]bool{"a.jpg": true, "b.jpg": true, "c.jpg": true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickFrom(images, "2024-01-15", tt.exclude, nil); got != tt.want {
+				t.Errorf("pickFrom() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetImageForDateAcrossTimezones(t *testing.T) {
+	cet := time.FixedZone("CET", 60*60)
+	// Still the 15th in UTC, just after the latest date below
+	now := time.Date(2024, 1, 16, 0, 31, 0, 0, cet)
+	mapper := NewImageMapper([]string{"a.jpg", "b.jpg", "c.jpg"}).WithClock(func() time.Time { return now })
+
+	tests := []struct {
+		name string
+		date time.Time
+		want string
+	}{
+		{"23:59 CET", time.Date(2024, 1, 15, 23, 59, 0, 0, cet), "a.jpg"},
+		{"00:01 CET", time.Date(2024, 1, 16, 0, 1, 0, 0, cet), "b.jpg"},
+		{"00:01 CET in UTC", time.Date(2024, 1, 16, 0, 1, 0, 0, cet).UTC(), "a.jpg"},
+		{"midnight CET", time.Date(2024, 1, 16, 0, 0, 0, 0, cet), "b.jpg"},
+		{"noon UTC", time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), "a.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				got, err := mapper.GetImageForDate(tt.date)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Fatalf("GetImageForDate(%v) = %q, want %q", tt.date, got, tt.want)
+				}
+			}
+		})
+	}
+
+	// The future is judged by the instant, not the wall clock
+	if _, err := mapper.GetImageForDate(now.Add(time.Minute).In(time.UTC)); err != ErrFutureDate {
+		t.Errorf("GetImageForDate() a minute after now: error = %v, want %v", err, ErrFutureDate)
+	}
+	if _, err := mapper.GetImageForDate(now.In(time.UTC)); err != nil {
+		t.Errorf("GetImageForDate() at now: %v", err)
+	}
+}
+
+func TestISOWeek(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"2024-01-01", "2024-W01"}, // A Monday
+		{"2024-01-07", "2024-W01"}, // The Sunday after
+		{"2024-01-08", "2024-W02"},
+		{"2021-01-03", "2020-W53"}, // A Sunday still in the last year's week
+		{"2024-12-30", "2025-W01"}, // A Monday already in the next year's week
+		{"2026-12-31", "2026-W53"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			if got := isoWeek(mustDate(t, tt.date)); got != tt.want {
+				t.Errorf("isoWeek(%s) = %q, want %q", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetImageForWeek(t *testing.T) {
+	now := mustDate(t, "2025-06-01")
+	mapper := NewImageMapper(testImages(10)).WithClock(func() time.Time { return now })
+
+	tests := []struct {
+		name   string
+		monday string
+	}{
+		{"first week", "2024-01-01"},
+		{"across months", "2024-01-29"},
+		{"across years", "2024-12-30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monday := mustDate(t, tt.monday)
+			want, err := mapper.GetImageForWeek(monday)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Every day until Sunday, at any time of day, gets Monday's image
+			for day := 0; day < 7; day++ {
+				for _, hour := range []int{0, 12, 23} {
+					date := monday.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+					if got, _ := mapper.GetImageForWeek(date); got != want {
+						t.Errorf("GetImageForWeek(%v) = %q, want %q", date, got, want)
+					}
+				}
+			}
+		})
+	}
+
+	// The image changes at week boundaries rather than staying the same
+	changes := 0
+	for week := 0; week < 52; week++ {
+		sunday := mustDate(t, "2024-01-07").AddDate(0, 0, 7*week)
+		before, _ := mapper.GetImageForWeek(sunday)
+		after, _ := mapper.GetImageForWeek(sunday.AddDate(0, 0, 1))
+		if before != after {
+			changes++
+		}
+	}
+	if changes < 26 {
+		t.Errorf("image changed at %d of 52 week boundaries, want most", changes)
+	}
+}
+
+func TestPooledImageMapperRatio(t *testing.T) {
+	poolOf := func(name string, weight float64) Pool {
+		var images []string
+		for i := 0; i < 5; i++ {
+			images = append(images, fmt.Sprintf("%s/%d.jpg", name, i))
+		}
+		return Pool{Name: name, Weight: weight, Images: images}
+	}
+
+	tests := []struct {
+		name  string
+		pools []Pool
+		want  map[string]float64 // Share of dates by pool
+	}{
+		{"equal", []Pool{poolOf("a", 1), poolOf("b", 1)}, map[string]float64{"a": 0.5, "b": 0.5}},
+		{"three to one", []Pool{poolOf("premium", 3), poolOf("regular", 1)}, map[string]float64{"premium": 0.75, "regular": 0.25}},
+		{"fractional", []Pool{poolOf("a", 0.2), poolOf("b", 0.3), poolOf("c", 0.5)}, map[string]float64{"a": 0.2, "b": 0.3, "c": 0.5}},
+		{"zero weight dropped", []Pool{poolOf("a", 1), poolOf("b", 0)}, map[string]float64{"a": 1}},
+		{"empty pool dropped", []Pool{poolOf("a", 1), {Name: "b", Weight: 5}}, map[string]float64{"a": 1}},
+	}
+	const days = 8000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := NewPooledImageMapper(tt.pools).WithClock(func() time.Time { return epoch.AddDate(0, 0, days) })
+			counts := make(map[string]int)
+			for day := 0; day < days; day++ {
+				date := epoch.AddDate(0, 0, day)
+				got, err := mapper.GetImageForDate(date)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if again, _ := mapper.GetImageForDate(date); again != got {
+					t.Fatalf("%s: picked %q, then %q", date.Format("2006-01-02"), got, again)
+				}
+				pool, _, _ := strings.Cut(got, "/")
+				counts[pool]++
+			}
+			for pool, share := range tt.want {
+				if got := float64(counts[pool]) / days; math.Abs(got-share) > 0.025 {
+					t.Errorf("pool %s picked on %.3f of dates, want %.3f", pool, got, share)
+				}
+			}
+			if len(counts) != len(tt.want) {
+				t.Errorf("picked from pools %v, want %v", counts, tt.want)
+			}
+		})
+	}
+}
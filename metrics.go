@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Daily selections, in total and per filename. The per-image counts are
+	// only kept while the pool has at most -selection-metrics-limit images,
+	// to bound the number of labels.
+	selectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "motd_image_selections_total",
+		Help: "Images selected as today's image.",
+	})
+	selectionsPerImage = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "motd_image_selections_per_image_total",
+		Help: "Daily selections by image, for pools up to -selection-metrics-limit images.",
+	}, []string{"filename"})
+
+	pageRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "motd_page_requests_total",
+		Help: "Page requests by status code.",
+	}, []string{"status"})
+	imagesFound = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "motd_images",
+		Help: "Images found by the last scan.",
+	})
+	rotations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "motd_image_rotations_total",
+		Help: "Images put in place as today's image.",
+	})
+	selectionErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "motd_image_selection_errors_total",
+		Help: "Failures to select today's image.",
+	})
+)
+
+// recordSelection counts name being selected as today's image from a pool of
+// poolSize images.
+func recordSelection(name string, poolSize int) {
+	selectionsTotal.Inc()
+	if poolSize <= selectionMetricsLimit {
+		selectionsPerImage.WithLabelValues(name).Inc()
+	}
+}
+
+// recordPageRequest counts a page request answered with status.
+func recordPageRequest(status int) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	pageRequests.WithLabelValues(strconv.Itoa(status)).Inc()
+}
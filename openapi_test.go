@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// routeRecorder is a router recording the patterns registered on it.
+type routeRecorder []string
+
+func (r *routeRecorder) Handle(pattern string, handler http.Handler) {
+	*r = append(*r, pattern)
+}
+
+func (r *routeRecorder) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	*r = append(*r, pattern)
+}
+
+// documents reports whether the documented path is served by the mux
+// pattern. Patterns ending in "/" serve every path below them.
+func documents(path, pattern string) bool {
+	pattern = strings.TrimSuffix(pattern, "{$}")
+	if pattern == "/" {
+		return path == "/"
+	}
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern)
+	}
+	return path == pattern
+}
+
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+
+	defer func(h bool, v, c, f string, s bool, a string) {
+		headless, variantAssetDir, collectionsDir, historyFile, serveSpec, adminToken = h, v, c, f, s, a
+	}(headless, variantAssetDir, collectionsDir, historyFile, serveSpec, adminToken)
+	variantAssetDir, collectionsDir, historyFile, serveSpec, adminToken = "variant", "collections", "history.json", true, "token"
+
+	// Every optional route is enabled by one of the modes
+	registered := make(map[string]bool)
+	for _, h := range []bool{false, true} {
+		headless = h
+		var routes routeRecorder
+		registerRoutes(&routes)
+		for _, pattern := range routes {
+			registered[pattern] = true
+		}
+	}
+
+	for pattern := range registered {
+		found := false
+		for path := range spec.Paths {
+			if documents(path, pattern) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("route %s is not documented in openapi.json", pattern)
+		}
+	}
+	for path := range spec.Paths {
+		found := false
+		for pattern := range registered {
+			if documents(path, pattern) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("openapi.json documents %s, which is not routed", path)
+		}
+	}
+}
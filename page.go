@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// pageHTML is the built-in page template, used unless -template is set.
+//
+//go:embed page.html
+var pageHTML string
+
+var pageTemplate = template.Must(template.New("page").Parse(pageHTML))
+
+// noImageSVG is the placeholder shown on the page while there's no image.
+//
+//go:embed no-image.svg
+var noImageSVG []byte
+
+// serveNoImage serves the placeholder graphic of the empty page.
+func serveNoImage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	http.ServeContent(w, r, "no-image.svg", startTime, bytes.NewReader(noImageSVG))
+}
+
+// loadTemplate replaces pageTemplate with the one in templateFile, if set.
+func loadTemplate() error {
+	if templateFile == "" {
+		return nil
+	}
+	tmpl, err := template.ParseFiles(templateFile)
+	if err != nil {
+		return err
+	}
+	pageTemplate = tmpl
+	return nil
+}
+
+// pageData is passed to pageTemplate when rendering the page.
+type pageData struct {
+	Title string // Localized title, like Text.Title
+	Date  string // Date the image was selected for, e.g. 2024-01-15
+	Lang  string
+	Text  messages
+
+	ImageURL string // Empty if there is no image to show
+	Srcset   string // Value of the srcset attribute of the image, if any
+	IsVideo  bool
+	Stale    bool
+	WeekURL  string
+	DayNav   bool    // Show arrows to the previous and, disabled, the next day
+	PrevURL  string  // /image/ URL of the previous day's image, if there is one
+	Link     string  // Where clicking the image leads, if anywhere
+	Caption  caption // Shown under the image
+
+	// Seconds until the page should be reloaded to show the next image,
+	// either by script or, with NoJS, a meta refresh.
+	RefreshSeconds int
+	NoJS           bool
+
+	// With the fade transition, the image fades in over FadeDuration (a CSS
+	// time). With CrossFade, the script fades to the next image in place
+	// instead of reloading the page.
+	FadeDuration string
+	FadeMillis   int
+	CrossFade    bool
+}
+
+// newPageData returns the page data for showing the asset filename.
+func newPageData(filename string) pageData {
+	now := time.Now()
+	data := pageData{
+		Title:          catalog[pageLang].Title,
+		Lang:           pageLang,
+		Text:           catalog[pageLang],
+		ImageURL:       "/assets/" + filename,
+		IsVideo:        isVideo(filename),
+		RefreshSeconds: refreshSeconds(now),
+		NoJS:           noJS,
+	}
+	if filename == "" {
+		// Check back soon, the scheduler retries failed updates
+		data.ImageURL = ""
+		data.RefreshSeconds = min(data.RefreshSeconds, emptyRefreshSeconds)
+	}
+	if transition == "fade" {
+		data.FadeMillis = int(transitionDuration.Milliseconds())
+		data.FadeDuration = fmt.Sprintf("%dms", data.FadeMillis)
+		data.CrossFade = !noJS && !data.IsVideo && data.ImageURL != ""
+	}
+	return data
+}
+
+// previousDayURL returns the /image/ URL of the day before date
+// (2006-01-02), or before today in the configured timezone if date is
+// empty. It's empty if that day precedes the epoch.
+func previousDayURL(date string) string {
+	day, err := time.ParseInLocation("2006-01-02", date, location)
+	if err != nil {
+		day = time.Now().In(location)
+	}
+	yesterday := day.AddDate(0, 0, -1)
+	if yesterday.Before(epoch) {
+		return ""
+	}
+	return "/image/" + yesterday.Format("2006-01-02")
+}
+
+// refreshDelay gives the server some seconds to renew the image before the
+// page reloads.
+const refreshDelay = 5
+
+// refreshSeconds returns how long after now the page should look for the
+// next image: until the next renewal plus refreshDelay.
+func refreshSeconds(now time.Time) int {
+	return int(nextRenewal(now).Sub(now).Seconds()) + refreshDelay
+}
+
+// emptyRefreshSeconds is how often the page reloads while there's no image.
+const emptyRefreshSeconds = 60
+
+// renderPage writes the HTML page for data to w.
+func renderPage(w io.Writer, data pageData) error {
+	return pageTemplate.Execute(w, data)
+}
+
+func servePage(w http.ResponseWriter, r *http.Request) {
+	rec := &responseRecorder{ResponseWriter: w}
+	defer func() { recordPageRequest(rec.status) }()
+	w = rec
+
+	variant := inVariant(r)
+	if accessLogFormat == "" {
+		if variant {
+			debugLogger.Printf("request from %s: %s %s (variant)", r.RemoteAddr, r.Method, r.URL.Path)
+		} else {
+			debugLogger.Printf("request from %s: %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+		}
+	}
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Accept-Language")
+	if prefersJSON(r) {
+		serveTodayInfo(w, r)
+		return
+	}
+	img := primary.image()
+	data := newPageData(img.filename)
+	data.Date = img.date
+	data.Srcset = srcsetAttr("/assets/", img.srcset)
+	data.Caption = img.caption
+	data.Lang = requestLang(r)
+	data.Text = catalog[data.Lang]
+	data.Title = data.Text.Title
+	data.Link = linkFor(img.source)
+	data.Stale = !hideStaleNote && img.isStale()
+	if showWeek {
+		data.WeekURL = "/week"
+	}
+	if dayNav {
+		data.DayNav = true
+		data.PrevURL = previousDayURL(img.date)
+	}
+	if key := r.URL.Query().Get("region"); key != "" && key != region {
+		data.ImageURL, data.Srcset, data.Link, data.Caption = "", "", "", caption{}
+		data.CrossFade = false                // The URL stays the same across days
+		data.DayNav, data.PrevURL = false, "" // /image/ doesn't take a region
+		selectedImage, err := selectRegionImage(key)
+		if err != nil {
+			errorLogger.Printf("Error selecting image for region '%s': %v", key, err)
+		} else {
+			data.ImageURL = "/today?" + url.Values{"region": {key}}.Encode()
+			data.IsVideo = isVideo(selectedImage)
+			data.Link = linkFor(selectedImage)
+			if path, err := sourcePath(selectedImage); err == nil {
+				data.Caption = readCaption(path, selectedImage)
+			}
+		}
+	}
+	// Render completely first, so a failing template yields a clean 500
+	var page bytes.Buffer
+	var err error
+	if variant {
+		err = variantTemplate.Execute(&page, data)
+	} else {
+		err = renderPage(&page, data)
+	}
+	if err != nil {
+		errorLogger.Printf("Error rendering page: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if data.ImageURL == "" {
+		// The scheduler keeps retrying, and the page reloads as often
+		w.Header().Set("Retry-After", strconv.Itoa(emptyRefreshSeconds))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	page.WriteTo(w)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runPlan prints the image selected for every date in a range, one
+// "date\tfilename" line each, without starting the server. With -interval,
+// there's a line per period, labeled as in periodLabel.
+func runPlan(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	registerFlags(fs)
+	var from, to string
+	fs.StringVar(&from, "from", "", "First date to plan, e.g. 2024-01-01 (default today)")
+	fs.StringVar(&to, "to", "", "Last date to plan (default 30 days after -from)")
+	fs.Parse(args)
+
+	closeLog := setup()
+	defer closeLog()
+
+	start, end, err := parseDateRange(from, to, 30)
+	if err != nil {
+		errorLogger.Fatalf("%v", err)
+	}
+
+	images, err := loadImages()
+	if err != nil {
+		errorLogger.Fatalf("Error getting image list: %v", err)
+	}
+	if len(images) == 0 {
+		errorLogger.Fatalf("No images available in the image directory")
+	}
+	mapper := newMapper(images)
+
+	output := bufio.NewWriter(os.Stdout)
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		for _, date := range periodStarts(day) {
+			selectedImage, err := previewImage(mapper, date, region)
+			if err != nil {
+				errorLogger.Fatalf("Error selecting image for %s: %v", periodLabel(date), err)
+			}
+			fmt.Fprintf(output, "%s\t%s\n", periodLabel(date), selectedImage)
+		}
+	}
+	if err := output.Flush(); err != nil {
+		errorLogger.Fatalf("Error writing plan: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// playlist is the order of -playlist-file, compacted to the images in the
+// pool by the last loadImages. It's guarded by primary.mutex.
+var playlist []string
+
+// readPlaylist returns the entries of the playlist file: one image name per
+// line, skipping blank lines and lines starting with #.
+func readPlaylist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+// compactPlaylist drops the entries that aren't among images, so indexing by
+// day always lands on a real file. Repeated entries are handled according to
+// -playlist-duplicates: skip keeps the first, keep keeps all, and error
+// rejects the playlist.
+func compactPlaylist(entries []string, images []imageInfo) ([]string, error) {
+	available := make(map[string]bool, len(images))
+	for _, image := range images {
+		available[image.Filename] = true
+	}
+
+	seen := make(map[string]bool, len(entries))
+	var compacted []string
+	for _, entry := range entries {
+		if !available[entry] {
+			warnLogger.Printf("Skipping playlist entry %s: not in the pool", entry)
+			continue
+		}
+		if seen[entry] {
+			switch playlistDuplicates {
+			case "error":
+				return nil, fmt.Errorf("playlist lists %s more than once", entry)
+			case "skip":
+				continue
+			}
+		}
+		seen[entry] = true
+		compacted = append(compacted, entry)
+	}
+	return compacted, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReadPlaylist(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "playlist.txt", []byte("# Summer\n  a.jpg  \n\nb.jpg\n#c.jpg\nnested/c.jpg\n"))
+	got, err := readPlaylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.jpg", "b.jpg", "nested/c.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("readPlaylist() = %v, want %v", got, want)
+	}
+	if _, err := readPlaylist(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("readPlaylist() of a missing file succeeded")
+	}
+}
+
+func TestCompactPlaylist(t *testing.T) {
+	images := []imageInfo{{Filename: "a.jpg"}, {Filename: "b.jpg"}, {Filename: "c.jpg"}}
+	entries := []string{"c.jpg", "missing.jpg", "a.jpg", "c.jpg", "b.jpg", "gone.jpg", "a.jpg"}
+
+	tests := []struct {
+		duplicates string
+		want       []string
+		wantErr    bool
+	}{
+		{"skip", []string{"c.jpg", "a.jpg", "b.jpg"}, false},
+		{"keep", []string{"c.jpg", "a.jpg", "c.jpg", "b.jpg", "a.jpg"}, false},
+		{"error", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.duplicates, func(t *testing.T) {
+			setupTest(t)
+			defer func(old string) { playlistDuplicates = old }(playlistDuplicates)
+			playlistDuplicates = tt.duplicates
+
+			got, err := compactPlaylist(entries, images)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compactPlaylist() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("compactPlaylist() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPlaylistOrder loads a playlist naming missing and repeated images and
+// checks that the days cycle through what's left of it.
+func TestPlaylistOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		playlist   string
+		duplicates string
+		want       []string
+		wantErr    bool
+	}{
+		{"in order", "a.jpg\nb.jpg\nc.jpg\n", "skip", []string{"a.jpg", "b.jpg", "c.jpg"}, false},
+		{"missing skipped", "c.jpg\nmissing.jpg\na.jpg\n", "skip", []string{"c.jpg", "a.jpg"}, false},
+		{"duplicates skipped", "b.jpg\na.jpg\nb.jpg\nmissing.jpg\n", "skip", []string{"b.jpg", "a.jpg"}, false},
+		{"duplicates kept", "b.jpg\na.jpg\nb.jpg\nmissing.jpg\n", "keep", []string{"b.jpg", "a.jpg", "b.jpg"}, false},
+		{"duplicates rejected", "b.jpg\na.jpg\nb.jpg\n", "error", nil, true},
+		{"nothing left", "missing.jpg\n", "skip", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			defer func(dir, file, duplicates string, entries []string) {
+				imageDir, playlistFile, playlistDuplicates, playlist = dir, file, duplicates, entries
+			}(imageDir, playlistFile, playlistDuplicates, playlist)
+			imageDir = t.TempDir()
+			for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+				writeTestJPEG(t, filepath.Join(imageDir, name))
+			}
+			playlistFile = writeTestFile(t, t.TempDir(), "playlist.txt", []byte(tt.playlist))
+			playlistDuplicates = tt.duplicates
+
+			images, err := loadImages()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// Start on a day that's a multiple of the playlist length, so
+			// it plays from the top
+			start := epoch.AddDate(0, 0, 360*len(tt.want))
+			mapper := newMapper(images).WithClock(func() time.Time { return start.AddDate(1, 0, 0) })
+			for day := 0; day < 2*len(tt.want); day++ {
+				date := start.AddDate(0, 0, day)
+				got, err := mapper.GetImageForDate(date)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := tt.want[day%len(tt.want)]; got != want {
+					t.Errorf("%s: got %q, want %q", date.Format("2006-01-02"), got, want)
+				}
+			}
+		})
+	}
+}
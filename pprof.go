@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofHandler serves the net/http/pprof endpoints under /debug/pprof/,
+// for the listener set with -pprof. Named profiles such as heap and
+// goroutine are served by pprof.Index.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
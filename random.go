@@ -0,0 +1,32 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// random is the source of every random choice the server makes. With
+// -random-seed it's seeded deterministically, making those choices
+// reproducible across restarts.
+var (
+	randomMutex sync.Mutex
+	random      *rand.Rand
+)
+
+// seedRandom seeds random with seed, or from the clock if seed is 0.
+func seedRandom(seed uint64) {
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	randomMutex.Lock()
+	random = rand.New(rand.NewPCG(seed, seed))
+	randomMutex.Unlock()
+}
+
+// randomInt64N returns a random number in [0, n).
+func randomInt64N(n int64) int64 {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	return random.Int64N(n)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanics recovers from panics in next, logging them with the stack
+// and answering 500 unless the response has already begun, instead of
+// leaving net/http to drop the connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+				panic(err)
+			}
+			errorLogger.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			if rec.status == 0 {
+				http.Error(rec, "internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
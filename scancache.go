@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// scanResult is a scan of an image directory: the images found and the
+// modification times of the directories walked.
+type scanResult struct {
+	dirs   map[string]time.Time
+	images []imageInfo
+}
+
+// dirTimeGranularity is the coarsest resolution of directory modification
+// times expected, e.g. 2s on FAT. Scans of directories changed more recently
+// aren't reused.
+const dirTimeGranularity = 2 * time.Second
+
+var (
+	scanMutex sync.Mutex
+	scans     = make(map[string]scanResult) // Last complete scan per directory
+)
+
+// cachedScan returns the images of the last scan of dir if none of the
+// directories walked changed since. Adding, removing or renaming a file
+// touches its directory, so that's caught; a file rewritten in place isn't,
+// until forgetScans.
+func cachedScan(dir string) ([]imageInfo, bool) {
+	scanMutex.Lock()
+	defer scanMutex.Unlock()
+
+	scan, ok := scans[dir]
+	if !ok {
+		return nil, false
+	}
+	for path, modTime := range scan.dirs {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			delete(scans, dir)
+			return nil, false
+		}
+	}
+	// Callers fill in weights, so they get a copy
+	return slices.Clone(scan.images), true
+}
+
+// storeScan remembers a complete scan of dir for cachedScan.
+func storeScan(dir string, dirs map[string]time.Time, images []imageInfo) {
+	scanMutex.Lock()
+	defer scanMutex.Unlock()
+	scans[dir] = scanResult{dirs: dirs, images: slices.Clone(images)}
+}
+
+// forgetScans makes the next scan of every directory walk it again.
+func forgetScans() {
+	scanMutex.Lock()
+	defer scanMutex.Unlock()
+	clear(scans)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scheduleFile is the name of the optional file in the image directory that
+// limits images to windows of dates.
+const scheduleFile = "schedule.txt"
+
+// dateWindow is the range of dates an image may be shown in, both ends
+// included. Yearly windows use MM-DD dates and wrap around the new year if
+// End precedes Start, e.g. 12-20 to 01-06; one-off windows use YYYY-MM-DD.
+type dateWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// parseWindow validates the window from start to end.
+func parseWindow(start, end string) (dateWindow, error) {
+	layout := "2006-01-02"
+	if len(start) == len("01-02") {
+		layout = "01-02"
+	}
+	if len(start) != len(end) {
+		return dateWindow{}, errors.New("start and end must both be MM-DD or YYYY-MM-DD")
+	}
+	for _, date := range []string{start, end} {
+		if _, err := time.Parse(layout, date); err != nil {
+			return dateWindow{}, fmt.Errorf("invalid date %q", date)
+		}
+	}
+	if layout == "2006-01-02" && end < start {
+		return dateWindow{}, fmt.Errorf("end %s precedes start %s", end, start)
+	}
+	return dateWindow{Start: start, End: end}, nil
+}
+
+// contains reports whether the day of date, in its location, is in w.
+func (w dateWindow) contains(date time.Time) bool {
+	if len(w.Start) == len("01-02") {
+		day := date.Format("01-02")
+		if w.End < w.Start {
+			return day >= w.Start || day <= w.End
+		}
+		return day >= w.Start && day <= w.End
+	}
+	day := date.Format("2006-01-02")
+	return day >= w.Start && day <= w.End
+}
+
+// readSchedule parses the schedule file at path: one "filename start end"
+// line per image, skipping blank lines and lines starting with #. A missing
+// file has no windows.
+func readSchedule(path string) (map[string]dateWindow, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	windows := make(map[string]dateWindow)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		// The dates are the last fields, so filenames may contain spaces
+		i := strings.LastIndexAny(text, " \t")
+		rest := strings.TrimRight(text[:max(i, 0)], " \t")
+		j := strings.LastIndexAny(rest, " \t")
+		if i < 0 || j < 0 {
+			return nil, fmt.Errorf("%s:%d: expected filename, start and end", path, line)
+		}
+		window, err := parseWindow(rest[j+1:], text[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		windows[strings.TrimSpace(rest[:j])] = window
+	}
+	return windows, scanner.Err()
+}
+
+// applySchedule sets the windows of images from the schedule file in dir.
+func applySchedule(dir string, images []imageInfo) error {
+	windows, err := readSchedule(filepath.Join(dir, scheduleFile))
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		if window, ok := windows[images[i].Filename]; ok {
+			images[i].Window = &window
+		}
+	}
+	return nil
+}
+
+// imageWindows returns the windows of images for the ImageMapper, or nil if
+// none has one.
+func imageWindows(images []imageInfo) map[string]dateWindow {
+	var windows map[string]dateWindow
+	for _, image := range images {
+		if image.Window == nil {
+			continue
+		}
+		if windows == nil {
+			windows = make(map[string]dateWindow)
+		}
+		windows[image.Filename] = *image.Window
+	}
+	return windows
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		start, end string
+		wantErr    bool
+	}{
+		{"12-01", "12-31", false},
+		{"12-20", "01-06", false}, // Wraps around the new year
+		{"2024-12-01", "2024-12-31", false},
+		{"2024-12-31", "2024-12-01", true},
+		{"12-01", "2024-12-31", true},
+		{"13-01", "12-31", true},
+		{"12-32", "12-31", true},
+		{"december", "january", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.start+" "+tt.end, func(t *testing.T) {
+			_, err := parseWindow(tt.start, tt.end)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseWindow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDateWindowContains(t *testing.T) {
+	december := dateWindow{"12-01", "12-31"}
+	holidays := dateWindow{"12-20", "01-06"}
+	once := dateWindow{"2024-12-01", "2024-12-31"}
+
+	tests := []struct {
+		name   string
+		window dateWindow
+		date   string
+		want   bool
+	}{
+		{"first day", december, "2024-12-01", true},
+		{"last day", december, "2025-12-31", true},
+		{"day before", december, "2024-11-30", false},
+		{"day after", december, "2025-01-01", false},
+		{"wrapped before new year", holidays, "2024-12-24", true},
+		{"wrapped after new year", holidays, "2025-01-06", true},
+		{"wrapped outside", holidays, "2025-01-07", false},
+		{"wrapped before start", holidays, "2024-12-19", false},
+		{"one-off", once, "2024-12-15", true},
+		{"one-off next year", once, "2025-12-15", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.contains(mustDate(t, tt.date)); got != tt.want {
+				t.Errorf("%v.contains(%s) = %v, want %v", tt.window, tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]dateWindow
+		wantErr bool
+	}{
+		{
+			name:    "windows",
+			content: "# Seasonal\nxmas.jpg 12-01 12-31\n\nmonkey in snow.jpg\t12-20  01-06\nparty/2024.jpg 2024-12-31 2024-12-31\n",
+			want: map[string]dateWindow{
+				"xmas.jpg":           {"12-01", "12-31"},
+				"monkey in snow.jpg": {"12-20", "01-06"},
+				"party/2024.jpg":     {"2024-12-31", "2024-12-31"},
+			},
+		},
+		{name: "missing end", content: "xmas.jpg 12-01\n", wantErr: true},
+		{name: "invalid date", content: "xmas.jpg 12-01 12-32\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), scheduleFile, []byte(tt.content))
+			got, err := readSchedule(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readSchedule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, err := readSchedule(filepath.Join(t.TempDir(), scheduleFile)); got != nil || err != nil {
+		t.Errorf("readSchedule() of a missing file = %v, %v, want no windows", got, err)
+	}
+}
+
+func TestScheduledImageOnlyInWindow(t *testing.T) {
+	images := append(testImages(4), "xmas.jpg")
+	windows := map[string]dateWindow{"xmas.jpg": {"12-01", "12-31"}}
+
+	for _, n := range []int{0, 2} {
+		mapper := NewImageMapper(images).WithWindows(windows)
+		start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		inDecember := 0
+		var picks []string
+		for day := 0; day < 2*365; day++ {
+			date := start.AddDate(0, 0, day)
+			img, err := mapper.GetImageForDateExcludingRecent(date, "", n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img == "xmas.jpg" {
+				if date.Month() != time.December {
+					t.Fatalf("exclude-recent %d: xmas.jpg selected on %s", n, date.Format("2006-01-02"))
+				}
+				inDecember++
+			}
+			// The windows apply to the excluded days too
+			for _, recent := range picks[max(0, len(picks)-n):] {
+				if img == recent {
+					t.Fatalf("exclude-recent %d: %s repeats on %s", n, img, date.Format("2006-01-02"))
+				}
+			}
+			picks = append(picks, img)
+		}
+		if inDecember == 0 {
+			t.Errorf("exclude-recent %d: xmas.jpg never selected in December", n)
+		}
+	}
+}
+
+func TestNoImageScheduled(t *testing.T) {
+	setupTest(t)
+	windows := map[string]dateWindow{"a.jpg": {"12-01", "12-31"}, "b.jpg": {"06-01", "06-30"}}
+	mapper := NewImageMapper([]string{"a.jpg", "b.jpg"}).WithWindows(windows)
+
+	// In March neither is eligible, so both are
+	seen := make(map[string]bool)
+	for day := 1; day <= 31; day++ {
+		img, err := mapper.GetImageForDate(time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[img] = true
+	}
+	if !seen["a.jpg"] || !seen["b.jpg"] {
+		t.Errorf("selected %v in March, want both images", seen)
+	}
+}
+
+func TestApplySchedule(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, scheduleFile), []byte("nested/xmas.jpg 12-01 12-31\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	images := []imageInfo{{Filename: "a.jpg"}, {Filename: "nested/xmas.jpg"}}
+	if err := applySchedule(dir, images); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]dateWindow{"nested/xmas.jpg": {"12-01", "12-31"}}
+	if got := imageWindows(images); !reflect.DeepEqual(got, want) {
+		t.Errorf("imageWindows() = %v, want %v", got, want)
+	}
+}
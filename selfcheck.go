@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+)
+
+// verifySelection checks at startup that today's image, just selected,
+// depends only on the images and settings, so a restart doesn't change it.
+// It warns if selecting again picks another one, or if the history file
+// recorded another image for today before the restart. The selection is
+// repeated on a fresh scan of the image directory, which reruns everything
+// depending on map order, with a new mapper whose caches are filled in
+// another order, by selecting the day before first.
+func verifySelection() {
+	// The featured and fallback images aren't selected
+	img := primary.image()
+	if featuredImage != "" || !strings.HasPrefix(img.filename, "today_") {
+		return
+	}
+
+	var images []imageInfo
+	if remoteImages() {
+		// Fetching the index again could legitimately change it
+		if infos := primary.infos.Load(); infos != nil {
+			images = *infos
+		}
+	} else {
+		forgetScans()
+		var err error
+		if images, err = primary.load(); err != nil {
+			warnLogger.Printf("Self-check: scanning the images again failed: %v", err)
+			return
+		}
+	}
+	mapper := newMapper(images)
+	selectImage(mapper, img.time.AddDate(0, 0, -1), region)
+	again, err := selectImage(mapper, img.time, region)
+	switch {
+	case err != nil:
+		warnLogger.Printf("Self-check: selecting today's image again failed: %v", err)
+	case again != img.source:
+		warnLogger.Printf("Self-check: selection isn't deterministic, today's image is %s but selecting again from a fresh scan picked %s", img.source, again)
+	default:
+		debugLogger.Printf("Self-check: selecting today's image again picked %s as well", again)
+	}
+
+	// Periods shorter than a day share the date in the history
+	if historyFile == "" || interval > 0 {
+		return
+	}
+	entries, err := readHistory()
+	if err != nil {
+		warnLogger.Printf("Self-check: error reading the history: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.Date == img.date && entry.Filename != img.source {
+			warnLogger.Printf("Self-check: today's image changed from %s to %s since it was recorded in the history; unless the images or settings changed, selection isn't deterministic", entry.Filename, img.source)
+			return
+		}
+	}
+}
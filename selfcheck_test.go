@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifySelection(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int    // -exclude-recent
+		source   string // Overrides the source of today's image, as if picked differently
+		wantWarn bool
+	}{
+		{"deterministic", 0, "", false},
+		{"deterministic excluding recent", 3, "", false},
+		{"changed", 0, "other.png", true},
+		{"changed excluding recent", 3, "other.png", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTest(t)
+			defer func(c *collection, dir string, n int) { primary, imageDir, excludeRecent = c, dir, n }(primary, imageDir, excludeRecent)
+			imageDir = t.TempDir()
+			for _, name := range []string{"a.png", "b.png", "c.png", "d.png", "e.png", "other.png"} {
+				writeTestPNG(t, filepath.Join(imageDir, name))
+			}
+			excludeRecent = tt.n
+			primary = newCollection("", imageDir, assetDir)
+			if !primary.update() {
+				t.Fatal("update() failed")
+			}
+			if tt.source != "" {
+				img := *primary.image()
+				if img.source == tt.source {
+					img.source = "a.png"
+				} else {
+					img.source = tt.source
+				}
+				primary.current.Store(&img)
+			}
+
+			var warnings bytes.Buffer
+			warnLogger = log.New(&warnings, "", 0)
+			verifySelection()
+			if got := strings.Contains(warnings.String(), "isn't deterministic"); got != tt.wantWarn {
+				t.Errorf("warned %v, want %v; warnings: %q", got, tt.wantWarn, warnings.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// srcsetImage is today's image or one of its scaled-down copies, offered
+// to browsers in the srcset of the page with its width.
+type srcsetImage struct {
+	Filename string
+	Width    int
+}
+
+// writeSrcset writes copies of img, decoded from format and installed as
+// name, scaled down to each of srcsetWidths narrower than the image. They're written to
+// dir with the width before the extension, e.g.
+// today_2024-01-15_1a2b3c4d_640w.jpg. The returned list ends with name
+// itself at its full width, and is empty if there are no widths or the
+// image is narrower than all of them.
+func writeSrcset(img image.Image, format, dir, name string) ([]srcsetImage, error) {
+	width := img.Bounds().Dx()
+	if len(srcsetWidths) == 0 || width <= srcsetWidths[0] {
+		return nil, nil
+	}
+	target, ok := thumbFormats[format]
+	if !ok {
+		target = thumbFormats["jpeg"]
+	}
+
+	var images []srcsetImage
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, scaledWidth := range srcsetWidths {
+		if scaledWidth >= width {
+			break
+		}
+		scaled := srcsetImage{fmt.Sprintf("%s_%dw%s", base, scaledWidth, target.ext), scaledWidth}
+		if err := writeScaled(scaleToFit(img, scaledWidth, 0), target.format, dir, scaled.Filename); err != nil {
+			return nil, err
+		}
+		images = append(images, scaled)
+	}
+	return append(images, srcsetImage{name, width}), nil
+}
+
+// srcsetAttr returns the value of the srcset attribute listing images,
+// whose URLs are their filenames after prefix.
+func srcsetAttr(prefix string, images []srcsetImage) string {
+	candidates := make([]string, len(images))
+	for i, image := range images {
+		candidates[i] = prefix + image.Filename + " " + strconv.Itoa(image.Width) + "w"
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// parseWidths parses the -srcset-widths flag.
+func parseWidths(config string) ([]int, error) {
+	var widths []int
+	for _, item := range splitList(config) {
+		width, err := strconv.Atoi(item)
+		if err != nil || width < 1 {
+			return nil, fmt.Errorf("expected a positive number of pixels, got %q", item)
+		}
+		widths = append(widths, width)
+	}
+	slices.Sort(widths)
+	return slices.Compact(widths), nil
+}
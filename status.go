@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeStatus replaces the -status-file with the date and filename of img,
+// today's image of the primary collection, as one "date\tfilename" line,
+// like an entry of the history. It's written to a temporary file that is
+// renamed into place, so readers never see it half-written.
+func writeStatus(img *installedImage) error {
+	tmp, err := os.CreateTemp(filepath.Dir(statusFile), ".status-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(img.date + "\t" + img.source + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), statusFile)
+}
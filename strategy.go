@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha256"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Strategy decides which image an ImageMapper shows in each selection
+// period. Implementations must be deterministic: the same mapper, period
+// and key always yield the same image.
+type Strategy interface {
+	// Select returns the image of im for the period numbered period since
+	// the epoch within the sequence identified by key, avoiding the images
+	// of the n periods before it where the strategy supports that. Unless
+	// documented otherwise, it selects among the images eligible in the
+	// period, see eligibleIn.
+	Select(im *ImageMapper, period int, key string, n int) string
+}
+
+// strategies maps the names accepted by -strategy to their Strategy.
+var strategies = map[string]Strategy{
+	"hash":           HashStrategy{},
+	"sequential":     SequentialStrategy{},
+	"random-shuffle": ShuffleStrategy{},
+}
+
+// HashStrategy picks the image with the highest hash score for each period,
+// using rendezvous hashing, so adding or removing an image only changes the
+// periods it wins or won. It's the only strategy honoring pools and weights.
+type HashStrategy struct{}
+
+func (HashStrategy) Select(im *ImageMapper, period int, key string, n int) string {
+	window := min(n, len(im.images)-1)
+	if window <= 0 {
+		return im.eligibleIn(period).pick(hashInput(im.periodKey(period), key), nil)
+	}
+	return im.recentChain(period/recentBlock, key, window)[period%recentBlock]
+}
+
+// Excluding recent images, picks are chained in blocks of recentBlock
+// periods. Each block's chain starts a warm-up before it, without anything
+// excluded, so it costs the same at any date: recentWarmup windows, or for
+// small pools as many periods as recentBudget image scores allow. Chains
+// almost always converge on the picks of the previous block within the
+// warm-up, but if they don't, e.g. when nearly the whole pool is excluded,
+// an image may repeat within the window where two blocks meet.
+const (
+	recentBlock  = 256
+	recentWarmup = 4
+	recentBudget = 1 << 18
+)
+
+// recentChain returns the picks of the periods of block for key, each among
+// the images eligible in its period, excluding the picks of the window
+// periods before it. They're computed once per mapper.
+func (im *ImageMapper) recentChain(block int, key string, window int) []string {
+	im.recentMutex.Lock()
+	defer im.recentMutex.Unlock()
+
+	if im.recentWindow != window || im.recentPicks == nil {
+		im.recentWindow = window
+		im.recentPicks = make(map[recentKey][]string)
+	}
+	if picks, ok := im.recentPicks[recentKey{block, key}]; ok {
+		return picks
+	}
+
+	first := block * recentBlock
+	start := max(0, first-max(recentWarmup*window, recentBudget/len(im.images)))
+	var picks []string
+	exclude := make(map[string]bool, window)
+	for i := start; i < first+recentBlock; i++ {
+		// When few images are eligible, only the most recent ones of them
+		// are excluded, leaving at least one candidate
+		view := im.eligibleIn(i)
+		clear(exclude)
+		for j := len(picks) - 1; j >= max(0, len(picks)-window) && len(exclude) < len(view.images)-1; j-- {
+			if view.Contains(picks[j]) {
+				exclude[picks[j]] = true
+			}
+		}
+		picks = append(picks, view.pick(hashInput(im.periodKey(i), key), exclude))
+	}
+	picks = picks[first-start:]
+	im.recentPicks[recentKey{block, key}] = picks
+	return picks
+}
+
+// SequentialStrategy cycles through the images in sorted order, one per
+// period, so each image is shown once before any is repeated. The seed and
+// key shift where the cycle starts. Adding or removing an image shifts the
+// images of all later positions.
+type SequentialStrategy struct{}
+
+func (SequentialStrategy) Select(im *ImageMapper, period int, key string, n int) string {
+	im = im.eligibleIn(period)
+	offset := 0
+	if start := im.seedKey(key); start != "" {
+		hash := sha256.Sum256([]byte("sequential:" + start))
+		offset = int(scoreImage(hash, "") % uint64(len(im.images)))
+	}
+	return im.images[(period+offset)%len(im.images)]
+}
+
+// ShuffleStrategy shows the images in a random order that is drawn anew,
+// from the seed and key, for each round through the pool, so each image is
+// shown once per round. Images may repeat across the boundary of two rounds.
+type ShuffleStrategy struct{}
+
+func (ShuffleStrategy) Select(im *ImageMapper, period int, key string, n int) string {
+	im = im.eligibleIn(period)
+	round := period / len(im.images)
+	roundHash := sha256.Sum256([]byte(hashInput("shuffle:"+strconv.Itoa(round), im.seedKey(key))))
+
+	// Sorting by score is a uniformly random permutation, barring ties
+	scores := make(map[string]uint64, len(im.images))
+	for _, img := range im.images {
+		scores[img] = scoreImage(roundHash, img)
+	}
+	order := slices.Clone(im.images)
+	slices.SortFunc(order, func(a, b string) int {
+		if scores[a] != scores[b] {
+			if scores[a] > scores[b] {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return order[period%len(im.images)]
+}
+
+// AnniversaryStrategy shows images around the anniversary of the day they
+// were taken: for each period, it picks like HashStrategy among the images
+// taken within Days of the same month and day in any year. When none are,
+// it picks among the undated images, or all of them if every one is dated.
+// Recent images aren't excluded.
+type AnniversaryStrategy struct {
+	Taken map[string]time.Time // When the dated images were taken
+	Days  int
+}
+
+func (s AnniversaryStrategy) Select(im *ImageMapper, period int, key string, n int) string {
+	im = im.eligibleIn(period)
+	date := epoch.AddDate(0, 0, period/im.periodsPerDay())
+	nearby := make(map[string]bool)
+	dated := make(map[string]bool)
+	for _, img := range im.images {
+		if taken, ok := s.Taken[img]; ok {
+			dated[img] = true
+			if nearAnniversary(taken, date, s.Days) {
+				nearby[img] = true
+			}
+		}
+	}
+
+	// Exclude everything but the candidates
+	exclude := make(map[string]bool)
+	for _, img := range im.images {
+		switch {
+		case len(nearby) > 0:
+			exclude[img] = !nearby[img]
+		case len(dated) < len(im.images):
+			exclude[img] = dated[img]
+		}
+	}
+	return im.pick(hashInput(im.periodKey(period), key), exclude)
+}
+
+// nearAnniversary reports whether date is within days of an anniversary of
+// taken. An image taken on February 29 has its anniversary on March 1 in
+// other years.
+func nearAnniversary(taken, date time.Time, days int) bool {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	for year := date.Year() - 1; year <= date.Year()+1; year++ {
+		anniversary := time.Date(year, taken.Month(), taken.Day(), 0, 0, 0, 0, time.UTC)
+		if day.Sub(anniversary).Abs() <= time.Duration(days)*24*time.Hour {
+			return true
+		}
+	}
+	return false
+}
+
+// selectionStrategy returns the Strategy selected by -strategy, or with
+// -anniversary an AnniversaryStrategy for images.
+func selectionStrategy(images []imageInfo) Strategy {
+	if !anniversary {
+		return strategies[strategy]
+	}
+	taken := make(map[string]time.Time)
+	for _, image := range images {
+		if date, err := time.Parse("2006-01-02", image.Taken); err == nil {
+			taken[image.Filename] = date
+		}
+	}
+	return AnniversaryStrategy{Taken: taken, Days: anniversaryDays}
+}
+
+// playlistStrategy shows the entries of a playlist in order, one per
+// period, starting over after the last one. Keys and n don't apply.
+type playlistStrategy struct {
+	entries []string
+}
+
+func (s playlistStrategy) Select(im *ImageMapper, period int, key string, n int) string {
+	return s.entries[period%len(s.entries)]
+}
+
+// seedKey combines the seed of im with key into a string identifying the
+// sequence, empty for the default, unseeded sequence. Both are labeled as in
+// hashInput, so a seed never names the same sequence as an equal key.
+func (im *ImageMapper) seedKey(key string) string {
+	s := ""
+	if im.seed != "" {
+		s += labeled("seed", im.seed)
+	}
+	if key != "" {
+		s += labeled("key", key)
+	}
+	return s
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testImages returns n image names.
+func testImages(n int) []string {
+	images := make([]string, n)
+	for i := range images {
+		images[i] = fmt.Sprintf("monkey%03d.jpg", i)
+	}
+	return images
+}
+
+func TestExcludeRecentNoRepeats(t *testing.T) {
+	tests := []struct {
+		images int
+		n      int
+		window int // Days no image may repeat within
+	}{
+		{10, 3, 3},
+		{20, 7, 7},
+		{50, 7, 7},
+		{200, 14, 14},
+		{8, 7, 7}, // The whole rest of the pool is excluded
+		{3, 7, 2}, // Too small, so the window shrinks
+		{1, 7, 0}, // Nothing to exclude
+		{500, 30, 30},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d images, %d days", tt.images, tt.n), func(t *testing.T) {
+			mapper := NewImageMapper(testImages(tt.images))
+			// Three years, spanning several blocks of the chain
+			start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+			var picks []string
+			for day := 0; day < 3*365; day++ {
+				date := start.AddDate(0, 0, day)
+				img, err := mapper.GetImageForDateExcludingRecent(date, "", tt.n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, recent := range picks[max(0, len(picks)-tt.window):] {
+					if img == recent {
+						t.Fatalf("%s: %s repeats within %d days", date.Format("2006-01-02"), img, tt.window)
+					}
+				}
+				picks = append(picks, img)
+			}
+		})
+	}
+}
+
+func TestExcludeRecentDeterministic(t *testing.T) {
+	images := testImages(40)
+	dates := []time.Time{
+		time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 7, 13, 0, 0, 0, 0, time.UTC), // Starts a block of the chain
+		time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC),
+	}
+
+	// The same dates looked up on fresh mappers in different orders, and
+	// under different keys
+	for _, key := range []string{"", "eu"} {
+		forward := NewImageMapper(images)
+		backward := NewImageMapper(images)
+		for i := range dates {
+			a, err := forward.GetImageForDateExcludingRecent(dates[i], key, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j := len(dates) - 1 - i
+			b, err := backward.GetImageForDateExcludingRecent(dates[j], key, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, _ := NewImageMapper(images).GetImageForDateExcludingRecent(dates[i], key, 7); a != want {
+				t.Errorf("key %q, %s: got %s after other dates, %s on its own", key, dates[i].Format("2006-01-02"), a, want)
+			}
+			if want, _ := NewImageMapper(images).GetImageForDateExcludingRecent(dates[j], key, 7); b != want {
+				t.Errorf("key %q, %s: got %s after other dates, %s on its own", key, dates[j].Format("2006-01-02"), b, want)
+			}
+		}
+	}
+}
+
+func BenchmarkExcludeRecent(b *testing.B) {
+	date := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, size := range []int{50, 500, 2000} {
+		images := testImages(size)
+		b.Run(fmt.Sprintf("%d images", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// A fresh mapper, as after every rescan
+				NewImageMapper(images).GetImageForDateExcludingRecent(date, "", 7)
+			}
+		})
+	}
+}
+
+func TestStrategiesDeterministic(t *testing.T) {
+	images := testImages(7)
+	reversed := slices.Clone(images)
+	slices.Reverse(reversed)
+	for name, strategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			a := NewImageMapper(images).WithSeed("seed")
+			b := NewImageMapper(reversed).WithSeed("seed")
+			for period := 0; period < 200; period++ {
+				if x, y := strategy.Select(a, period, "key", 0), strategy.Select(b, period, "key", 0); x != y {
+					t.Fatalf("period %d: selected %q, then %q", period, x, y)
+				}
+			}
+		})
+	}
+}
+
+func TestSeedDistinctFromKey(t *testing.T) {
+	images := testImages(10)
+	seeded := NewImageMapper(images).WithSeed("eu")
+	keyed := NewImageMapper(images)
+	for name, strategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			same := 0
+			for period := 0; period < 200; period++ {
+				if strategy.Select(seeded, period, "", 0) == strategy.Select(keyed, period, "eu", 0) {
+					same++
+				}
+			}
+			// A seed equal to the key must not reproduce the keyed sequence
+			if same == 200 {
+				t.Errorf("seed %q selects as key %q", "eu", "eu")
+			}
+		})
+	}
+}
+
+func TestHashStrategyStable(t *testing.T) {
+	images := testImages(10)
+	tests := []struct {
+		name    string
+		changed []string
+		image   string // The image added or removed
+	}{
+		{"added", append(slices.Clone(images), "new.jpg"), "new.jpg"},
+		{"removed", slices.Delete(slices.Clone(images), 3, 4), images[3]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := NewImageMapper(images), NewImageMapper(tt.changed)
+			changes := 0
+			for period := 0; period < 1000; period++ {
+				x, y := HashStrategy{}.Select(before, period, "", 0), HashStrategy{}.Select(after, period, "", 0)
+				if x != y {
+					changes++
+					// Only the periods the image wins or won change
+					if x != tt.image && y != tt.image {
+						t.Fatalf("period %d changed from %q to %q", period, x, y)
+					}
+				}
+			}
+			if changes == 0 {
+				t.Error("no period changed")
+			}
+		})
+	}
+}
+
+func TestSequentialStrategy(t *testing.T) {
+	images := []string{"c.jpg", "a.jpg", "d.jpg", "b.jpg"}
+	sorted := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}
+	tests := []struct {
+		name  string
+		seed  string
+		start int // Index in sorted of the image of period 0, or -1 for any
+	}{
+		{"unseeded", "", 0},
+		{"seeded", "seed", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			im := NewImageMapper(images).WithSeed(tt.seed)
+			first := SequentialStrategy{}.Select(im, 0, "", 0)
+			start := slices.Index(sorted, first)
+			if tt.start >= 0 && start != tt.start {
+				t.Errorf("period 0 selected %q, want %q", first, sorted[tt.start])
+			}
+			// Each period shows the next image in sorted order
+			for period := 0; period < 3*len(images); period++ {
+				want := sorted[(start+period)%len(sorted)]
+				if got := (SequentialStrategy{}).Select(im, period, "", 0); got != want {
+					t.Errorf("period %d selected %q, want %q", period, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestShuffleStrategy(t *testing.T) {
+	images := testImages(8)
+	orders := make(map[string]bool)
+	for _, seed := range []string{"", "other"} {
+		im := NewImageMapper(images).WithSeed(seed)
+		for round := 0; round < 20; round++ {
+			// Each image is shown once per round
+			var order []string
+			for i := range images {
+				order = append(order, ShuffleStrategy{}.Select(im, round*len(images)+i, "", 0))
+			}
+			sorted := slices.Sorted(slices.Values(order))
+			if !slices.Equal(sorted, images) {
+				t.Fatalf("seed %q round %d showed %v", seed, round, order)
+			}
+			orders[strings.Join(order, ",")] = true
+		}
+	}
+	// Rounds and seeds draw different orders
+	if len(orders) < 30 {
+		t.Errorf("only %d distinct orders in 40 rounds", len(orders))
+	}
+}
+
+func TestAnniversaryStrategy(t *testing.T) {
+	taken := map[string]time.Time{
+		"bastille.jpg": mustDate(t, "2020-07-14"),
+		"leap.jpg":     mustDate(t, "2016-02-29"),
+		"newyear.jpg":  mustDate(t, "2019-12-31"),
+	}
+	dated := []string{"bastille.jpg", "leap.jpg", "newyear.jpg"}
+	all := append(slices.Clone(dated), "undated1.jpg", "undated2.jpg")
+
+	tests := []struct {
+		name   string
+		images []string
+		date   string
+		want   []string // Any of them
+	}{
+		{"on the day", all, "2024-07-14", []string{"bastille.jpg"}},
+		{"within days", all, "2024-07-17", []string{"bastille.jpg"}},
+		{"leap day in a leap year", all, "2024-02-29", []string{"leap.jpg"}},
+		{"leap day in another year", all, "2025-03-03", []string{"leap.jpg"}},
+		{"across the new year", all, "2025-01-02", []string{"newyear.jpg"}},
+		{"none nearby", all, "2024-10-01", []string{"undated1.jpg", "undated2.jpg"}},
+		{"none nearby, all dated", dated, "2024-10-01", dated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			im := NewImageMapper(tt.images)
+			date := mustDate(t, tt.date)
+			got := AnniversaryStrategy{Taken: taken, Days: 3}.Select(im, dayNumber(date), "", 0)
+			if !slices.Contains(tt.want, got) {
+				t.Errorf("%s: selected %q, want one of %v", tt.date, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// testVectorAlgorithm documents the selection the test vectors exercise.
+const testVectorAlgorithm = `Rendezvous hashing over the image list, sorted by byte order. ` +
+	`The input is the date formatted as YYYY-MM-DD, prefixed with "key:<n>:<key>:" for a non-empty key, ` +
+	`then with "seed:<n>:<seed>:" for a non-empty seed (none in these vectors), ` +
+	`where <n> is the length in bytes of the UTF-8 key or seed in decimal. ` +
+	`dateHash = SHA-256(UTF-8 input). For each image, score = first 8 bytes, big-endian, of ` +
+	`SHA-256(dateHash || UTF-8 image name). The image with the highest score is selected; ` +
+	`on equal scores, the lexicographically smallest name wins.`
+
+// Fixed inputs of the test vectors. Changing them changes the document, so
+// only ever add to them.
+var (
+	testVectorImages = []string{"a.jpg", "b.jpg", "cat.jpeg", "monkey 1.jpg", "zebra.JPG", "ü.jpg", "nested/x.jpg"}
+	testVectorDates  = []string{"2000-01-01", "2000-02-29", "2012-12-21", "2024-01-01", "2024-02-29", "2024-12-31", "2038-01-19"}
+	testVectorKeys   = []string{"", "eu"}
+)
+
+type testVectors struct {
+	Algorithm string       `json:"algorithm"`
+	Images    []string     `json:"images"`
+	Vectors   []testVector `json:"vectors"`
+}
+
+type testVector struct {
+	Date     string `json:"date"`
+	Key      string `json:"key,omitempty"`
+	Input    string `json:"input"`
+	Selected string `json:"selected"`
+	Score    string `json:"score"` // 16 hex digits
+}
+
+// runTestVectors prints conformance vectors of the daily selection, for
+// checking other implementations against this one.
+func runTestVectors(args []string) {
+	fs := flag.NewFlagSet("testvectors", flag.ExitOnError)
+	var out string
+	fs.StringVar(&out, "out", "", "File to write the test vectors to (default stdout)")
+	fs.Parse(args)
+
+	images := append([]string(nil), testVectorImages...)
+	sort.Strings(images)
+
+	doc := testVectors{Algorithm: testVectorAlgorithm, Images: images}
+	for _, key := range testVectorKeys {
+		for _, date := range testVectorDates {
+			input := hashInput(date, key)
+			selected := pickFrom(images, input, nil, nil)
+			doc.Vectors = append(doc.Vectors, testVector{
+				Date:     date,
+				Key:      key,
+				Input:    input,
+				Selected: selected,
+				Score:    fmt.Sprintf("%016x", scoreImage(sha256.Sum256([]byte(input)), selected)),
+			})
+		}
+	}
+
+	output := os.Stdout
+	if out != "" {
+		file, err := os.Create(out)
+		if err != nil {
+			log.Fatalf("Error creating test vector file: %v", err)
+		}
+		defer file.Close()
+		output = file
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		log.Fatalf("Error writing test vectors: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+)
+
+// thumbFormats maps decoded image formats to the format their thumbnails
+// are encoded in and its extension. GIFs become PNGs rather than being
+// quantized again.
+var thumbFormats = map[string]struct{ format, ext string }{
+	"jpeg": {"jpeg", ".jpg"},
+	"png":  {"png", ".png"},
+	"gif":  {"png", ".png"},
+}
+
+// writeThumbnail scales img, decoded from format, down to fit thumbSize x
+// thumbSize and writes it to dir as thumb_<label>, label naming the
+// installed image as in its filename. It returns the name of the written
+// file.
+func writeThumbnail(img image.Image, format, dir, label string) (string, error) {
+	target, ok := thumbFormats[format]
+	if !ok {
+		target = thumbFormats["jpeg"]
+	}
+	name := "thumb_" + label + target.ext
+	return name, writeScaled(scaleToFit(img, thumbSize, thumbSize), target.format, dir, name)
+}
+
+// writeScaled encodes img in format to dir as name, through a temporary
+// file that is renamed into place.
+func writeScaled(img image.Image, format, dir, name string) error {
+	tmp, err := os.CreateTemp(dir, ".scaled-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := encoders[format](tmp, img); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// offsetPattern matches fixed UTC offsets like UTC+2, GMT-05:30 or +0100.
+var offsetPattern = regexp.MustCompile(`^(?i:UTC|GMT)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// zoneinfoDirs are the directories time.LoadLocation looks for the IANA tz
+// database in on Unix systems, after $ZONEINFO.
+var zoneinfoDirs = []string{"/usr/share/zoneinfo/", "/usr/share/lib/zoneinfo/", "/usr/lib/locale/TZ/", "/etc/zoneinfo/"}
+
+// loadLocation loads the timezone name: a name from the IANA tz database
+// like Europe/Berlin, Local for the system's timezone, or a fixed offset
+// from UTC like UTC+2, GMT-05:30 or +0100. For unknown names, the error
+// suggests similar ones from the database.
+func loadLocation(name string) (*time.Location, error) {
+	if strings.EqualFold(name, "local") {
+		return time.Local, nil
+	}
+	// Offsets come first: the tz database has POSIX zones like GMT+2,
+	// whose sign is inverted
+	if m := offsetPattern.FindStringSubmatch(name); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes := 0
+		if m[3] != "" {
+			minutes, _ = strconv.Atoi(m[3])
+		}
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("offset %s is out of range", name)
+		}
+		offset := (hours*60 + minutes) * 60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err == nil {
+		return loc, nil
+	}
+
+	msg := fmt.Sprintf("unknown timezone %q", name)
+	if similar := similarZones(name); len(similar) > 0 {
+		msg += " (did you mean " + strings.Join(similar, " or ") + "?)"
+	}
+	return nil, fmt.Errorf("%s; names come from the IANA tz database, e.g. Europe/Berlin, and Local and offsets like UTC+2 work too", msg)
+}
+
+// similarZones returns up to three names of the tz database closest to
+// name, ignoring case, that are only a few edits away.
+func similarZones(name string) []string {
+	type candidate struct {
+		zone     string
+		distance int
+	}
+	var candidates []candidate
+	limit := max(1, len(name)/4)
+	for _, zone := range zoneNames() {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(zone)); d <= limit {
+			candidates = append(candidates, candidate{zone, d})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int { return a.distance - b.distance })
+
+	// A name differing only in case is surely the one meant
+	if len(candidates) > 0 && candidates[0].distance == 0 {
+		candidates = candidates[:1]
+	}
+	var similar []string
+	for _, c := range candidates[:min(3, len(candidates))] {
+		similar = append(similar, c.zone)
+	}
+	return similar
+}
+
+// zoneNames lists the names in the first tz database found on the system,
+// sorted, or none if there isn't one.
+func zoneNames() []string {
+	dirs := zoneinfoDirs
+	if dir := os.Getenv("ZONEINFO"); dir != "" {
+		dirs = append([]string{dir}, dirs...)
+	}
+	for _, dir := range dirs {
+		var names []string
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			name, _ := filepath.Rel(dir, path)
+			// Skip the posix/ and right/ copies and files like zone.tab
+			if first := name[0]; first >= 'A' && first <= 'Z' && !strings.Contains(name, ".") {
+				names = append(names, filepath.ToSlash(name))
+			}
+			return nil
+		})
+		if len(names) > 0 {
+			slices.Sort(names)
+			return names
+		}
+	}
+	return nil
+}
+
+// editDistance returns the Levenshtein distance between a and b, counting
+// the bytes inserted, deleted or substituted to turn one into the other.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
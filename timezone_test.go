@@ -0,0 +1,123 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantName   string
+		wantOffset int // Seconds east of UTC in January, if wantName is empty
+		wantErr    bool
+	}{
+		{name: "Europe/Berlin", wantName: "Europe/Berlin"},
+		{name: "UTC", wantName: "UTC"},
+		{name: "Local", wantName: "Local"},
+		{name: "local", wantName: "Local"},
+		{name: "UTC+2", wantOffset: 2 * 60 * 60},
+		{name: "utc+2", wantOffset: 2 * 60 * 60},
+		{name: "GMT-05:30", wantOffset: -(5*60 + 30) * 60},
+		{name: "+0100", wantOffset: 60 * 60},
+		{name: "-1", wantOffset: -60 * 60},
+		{name: "UTC+14", wantOffset: 14 * 60 * 60},
+		{name: "UTC+15", wantErr: true},
+		{name: "UTC+2:60", wantErr: true},
+		{name: "Europe/Berlni", wantErr: true},
+		{name: "Mars/Olympus_Mons", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := loadLocation(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadLocation(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantName != "" {
+				if loc.String() != tt.wantName {
+					t.Errorf("loadLocation(%q) = %s, want %s", tt.name, loc, tt.wantName)
+				}
+				return
+			}
+			if _, offset := time.Date(2024, 1, 15, 12, 0, 0, 0, loc).Zone(); offset != tt.wantOffset {
+				t.Errorf("loadLocation(%q) offset = %d, want %d", tt.name, offset, tt.wantOffset)
+			}
+		})
+	}
+}
+
+// setupZoneinfo points ZONEINFO at a small stand-in for the tz database.
+func setupZoneinfo(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"Europe/Berlin", "Europe/Paris", "Europe/Bern", "America/New_York", "Asia/Tokyo", "UTC", "posix/Europe/Berlin", "zone.tab"} {
+		writeTestFile(t, filepath.Join(dir, filepath.Dir(name)), filepath.Base(name), nil)
+	}
+	t.Setenv("ZONEINFO", dir)
+}
+
+func TestSimilarZones(t *testing.T) {
+	setupZoneinfo(t)
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Europe/Berlni", []string{"Europe/Berlin", "Europe/Bern"}}, // Closest first
+		{"europe/berlin", []string{"Europe/Berlin"}},                // Only the case differs
+		{"Europe/Bren", []string{"Europe/Bern"}},
+		{"America/NewYork", []string{"America/New_York"}},
+		{"Mars/Olympus_Mons", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := similarZones(tt.name); !slices.Equal(got, tt.want) {
+				t.Errorf("similarZones(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	// The suggestions make it into the error
+	if _, err := loadLocation("Europe/Berlni"); err == nil || !strings.Contains(err.Error(), "did you mean Europe/Berlin or Europe/Bern?") {
+		t.Errorf("loadLocation() error = %v, want a suggestion", err)
+	}
+}
+
+func TestZoneNames(t *testing.T) {
+	setupZoneinfo(t)
+	want := []string{"America/New_York", "Asia/Tokyo", "Europe/Berlin", "Europe/Bern", "Europe/Paris", "UTC"}
+	if got := zoneNames(); !slices.Equal(got, want) {
+		t.Errorf("zoneNames() = %v, want %v", got, want)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"berlin", "berlin", 0},
+		{"berlni", "berlin", 2},
+		{"bern", "berlin", 2},
+		{"kitten", "sitting", 3},
+		{"newyork", "new_york", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := editDistance(tt.a, tt.b); got != tt.want {
+				t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if got := editDistance(tt.b, tt.a); got != tt.want {
+				t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"hash/fnv"
+	"html/template"
+	"net/http"
+)
+
+// variantTemplate renders the page for the -variant-percent share of
+// clients. It receives the same pageData as pageTemplate.
+var variantTemplate *template.Template
+
+// loadVariant parses the variant template, if one is configured.
+func loadVariant() error {
+	if variantTemplateFile == "" {
+		return nil
+	}
+	tmpl, err := template.ParseFiles(variantTemplateFile)
+	if err != nil {
+		return err
+	}
+	variantTemplate = tmpl
+	return nil
+}
+
+// inVariant reports whether r is served the variant page. Clients are
+// assigned by a hash of their IP address, as clientIP finds it, so each one
+// sees the same page on every request, also behind a -trust-proxy proxy.
+func inVariant(r *http.Request) bool {
+	if variantTemplate == nil || variantPercent <= 0 {
+		return false
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(clientIP(r)))
+	return int(hash.Sum32()%100) < variantPercent
+}
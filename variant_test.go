@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInVariantBehindProxy(t *testing.T) {
+	defer func(tmpl *template.Template, percent int, trust bool) {
+		variantTemplate, variantPercent, trustProxy = tmpl, percent, trust
+	}(variantTemplate, variantPercent, trustProxy)
+	variantTemplate = template.New("variant")
+	variantPercent = 50
+
+	// request is a request from client, through the proxy if one is given.
+	request := func(client, proxy string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = client + ":1234"
+		if proxy != "" {
+			r.RemoteAddr = proxy + ":1234"
+			r.Header.Set("X-Forwarded-For", "203.0.113.99, "+client)
+		}
+		return r
+	}
+
+	const proxy = "10.0.0.1"
+	trustProxy = false
+	behindProxy := inVariant(request(proxy, ""))
+
+	assigned := make(map[bool]int)
+	for i := 0; i < 50; i++ {
+		client := fmt.Sprintf("192.0.2.%d", i)
+
+		trustProxy = false
+		direct := inVariant(request(client, ""))
+		if got := inVariant(request(client, proxy)); got != behindProxy {
+			t.Fatalf("%s without -trust-proxy: variant %v, want the proxy's %v", client, got, behindProxy)
+		}
+
+		// With -trust-proxy, each client gets the page it would get
+		// without the proxy
+		trustProxy = true
+		if got := inVariant(request(client, proxy)); got != direct {
+			t.Fatalf("%s with -trust-proxy: variant %v, want %v", client, got, direct)
+		}
+		assigned[direct]++
+	}
+	if assigned[true] == 0 || assigned[false] == 0 {
+		t.Errorf("clients assigned %v, want both pages", assigned)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchImages renews today's image when files in the image directory are
+// added, removed or changed, until ctx is done. A change only triggers once
+// the directory has been unchanged for watchInterval, so a batch upload
+// results in a single update. Changes are reported by notifyChanges; if
+// that fails, e.g. for lack of inotify watches, the directory is polled
+// every watchPollInterval.
+func watchImages(ctx context.Context) {
+	changes, err := notifyChanges(ctx, imageDir)
+	if err != nil {
+		warnLogger.Printf("Polling the image directory every %v: %v", watchPollInterval, err)
+		changes = pollChanges(ctx, imageDir, watchPollInterval)
+	}
+
+	settle := time.NewTimer(watchInterval)
+	settle.Stop()
+	defer settle.Stop()
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				if ctx.Err() == nil {
+					warnLogger.Printf("Polling the image directory every %v instead", watchPollInterval)
+					changes = pollChanges(ctx, imageDir, watchPollInterval)
+				}
+				continue
+			}
+			settle.Reset(watchInterval)
+		case <-settle.C:
+			logger.Println("Image directory changed")
+			forgetScans() // Also catches files rewritten in place
+			primary.update()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notifyChanges watches dir and its subdirectories with fsnotify until ctx
+// is done, sending on the returned channel when a file that can change the
+// pool changed. Subdirectories created later are watched as they appear.
+// The channel is closed when watching stops, e.g. because of an error.
+func notifyChanges(ctx context.Context, dir string) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	if err := addWatches(watcher, dir, dirs); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if changedBy(watcher, event, dirs) {
+					notifyChange(changes)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				if !errors.Is(err, fsnotify.ErrEventOverflow) {
+					errorLogger.Printf("Error watching the image directory: %v", err)
+					return
+				}
+				// Events were lost, so anything may have changed
+				if err := addWatches(watcher, dir, dirs); err != nil {
+					warnLogger.Printf("Error watching new image directories: %v", err)
+				}
+				notifyChange(changes)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// addWatches adds a watch for dir and each directory below it, skipping the
+// asset directory, and records them in dirs. Directories watched already
+// are kept.
+func addWatches(watcher *fsnotify.Watcher, dir string, dirs map[string]bool) error {
+	assetInfo, _ := os.Stat(assetDir)
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Gone again before it could be watched
+			if path != dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && assetInfo != nil && os.SameFile(info, assetInfo) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return err
+		}
+		dirs[path] = true
+		return nil
+	})
+}
+
+// changedBy reports whether event can change the pool. Directories that
+// appeared with it are watched as well.
+func changedBy(watcher *fsnotify.Watcher, event fsnotify.Event, dirs map[string]bool) bool {
+	switch {
+	case event.Has(fsnotify.Create):
+		// A directory moved in may hold images already
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := addWatches(watcher, event.Name, dirs); err != nil {
+				warnLogger.Printf("Error watching new image directories: %v", err)
+			}
+			return true
+		}
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		if dirs[event.Name] {
+			delete(dirs, event.Name)
+			return true
+		}
+	}
+	return watchedFile(filepath.Base(event.Name))
+}
+
+// pollChanges compares the fingerprint of dir every interval until ctx is
+// done, and sends on the returned channel once it changed and then stayed
+// the same for an interval. The channel is closed when polling stops.
+func pollChanges(ctx context.Context, dir string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	last := fingerprintDir(dir)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		pending := false
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			current := fingerprintDir(dir)
+			if current != last {
+				last = current
+				pending = true
+				continue
+			}
+			if pending {
+				pending = false
+				notifyChange(changes)
+			}
+		}
+	}()
+	return changes
+}
+
+// notifyChange sends on changes unless a change is pending already.
+func notifyChange(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// watchedFile reports whether a file named name can change the pool.
+func watchedFile(name string) bool {
+	return isMedia(name) || name == weightsFile || name == scheduleFile
+}
+
+// fingerprintDir returns a digest of the names, sizes and modification
+// times of the media files below dir, skipping the asset directory.
+func fingerprintDir(dir string) string {
+	hash := sha256.New()
+	assetInfo, _ := os.Stat(assetDir)
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if info, err := entry.Info(); err == nil && assetInfo != nil && os.SameFile(info, assetInfo) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !watchedFile(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return string(hash.Sum(nil))
+}
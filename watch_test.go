@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchChanges(t *testing.T) {
+	watchers := []struct {
+		name  string
+		watch func(ctx context.Context, dir string) (<-chan struct{}, error)
+	}{
+		{"notify", notifyChanges},
+		{"poll", func(ctx context.Context, dir string) (<-chan struct{}, error) {
+			return pollChanges(ctx, dir, 10*time.Millisecond), nil
+		}},
+	}
+	tests := []struct {
+		name   string
+		nested bool // Whether the asset directory is inside the image directory
+		// change changes dir, calling drain to forget the changes seen so far
+		change func(t *testing.T, dir string, drain func())
+		want   bool
+	}{
+		{
+			name: "added",
+			change: func(t *testing.T, dir string, drain func()) {
+				writeTestFile(t, dir, "b.jpg", []byte("b"))
+			},
+			want: true,
+		},
+		{
+			name: "rewritten in place",
+			change: func(t *testing.T, dir string, drain func()) {
+				writeTestFile(t, dir, "a.jpg", []byte("aa"))
+			},
+			want: true,
+		},
+		{
+			name: "removed",
+			change: func(t *testing.T, dir string, drain func()) {
+				if err := os.Remove(filepath.Join(dir, "a.jpg")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{
+			name: "weights",
+			change: func(t *testing.T, dir string, drain func()) {
+				writeTestFile(t, dir, weightsFile, []byte("a.jpg 3\n"))
+			},
+			want: true,
+		},
+		{
+			name: "added to a new directory",
+			change: func(t *testing.T, dir string, drain func()) {
+				sub := filepath.Join(dir, "new")
+				if err := os.Mkdir(sub, 0755); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(100 * time.Millisecond)
+				drain()
+				writeTestFile(t, sub, "c.jpg", []byte("c"))
+			},
+			want: true,
+		},
+		{
+			name: "other file",
+			change: func(t *testing.T, dir string, drain func()) {
+				writeTestFile(t, dir, "notes.txt", []byte("not an image"))
+			},
+		},
+		{
+			name:   "asset directory",
+			nested: true,
+			change: func(t *testing.T, dir string, drain func()) {
+				writeTestFile(t, assetDir, "today.jpg", []byte("a"))
+			},
+		},
+	}
+	for _, watcher := range watchers {
+		for _, tt := range tests {
+			t.Run(watcher.name+"/"+tt.name, func(t *testing.T) {
+				setupTest(t)
+				dir := t.TempDir()
+				writeTestFile(t, dir, "a.jpg", []byte("a"))
+				if tt.nested {
+					assetDir = filepath.Join(dir, "assets")
+					if err := os.Mkdir(assetDir, 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				changes, err := watcher.watch(ctx, dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Wait for the watcher to stop before the settings are
+				// restored
+				defer func() {
+					cancel()
+					for range changes {
+					}
+				}()
+				drain := func() {
+					select {
+					case <-changes:
+					default:
+					}
+				}
+
+				tt.change(t, dir, drain)
+				timeout := 300 * time.Millisecond
+				if tt.want {
+					timeout = 5 * time.Second
+				}
+				select {
+				case <-changes:
+					if !tt.want {
+						t.Error("reported a change")
+					}
+				case <-time.After(timeout):
+					if tt.want {
+						t.Error("didn't report the change")
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"time"
+)
+
+const (
+	weekDays      = 7
+	weekColumns   = 4
+	weekThumbSize = 240
+	weekPadding   = 16
+	weekLabelSize = 3 // Scale of the 3x5 label font
+)
+
+// serveWeekPreview renders thumbnails of the next week's picks, labeled
+// with their dates, as a single PNG grid.
+func serveWeekPreview(w http.ResponseWriter, r *http.Request) {
+	mapper := primary.mapper.Load()
+	if mapper == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no images available"})
+		return
+	}
+
+	labelHeight := 5*weekLabelSize + weekPadding
+	cellW := weekThumbSize + weekPadding
+	cellH := weekThumbSize + labelHeight + weekPadding
+	rows := (weekDays + weekColumns - 1) / weekColumns
+	sprite := image.NewRGBA(image.Rect(0, 0, weekColumns*cellW+weekPadding, rows*cellH+weekPadding))
+	draw.Draw(sprite, sprite.Bounds(), image.NewUniform(color.RGBA{0x12, 0x12, 0x12, 0xff}), image.Point{}, draw.Src)
+
+	today := time.Now().In(location)
+	for i := 0; i < weekDays; i++ {
+		date := today.AddDate(0, 0, i)
+		cellX := weekPadding + (i%weekColumns)*cellW
+		cellY := weekPadding + (i/weekColumns)*cellH
+		drawLabel(sprite, cellX, cellY, weekLabelSize, date.Format("2006-01-02"), color.White)
+
+		tile := image.Rect(cellX, cellY+labelHeight, cellX+weekThumbSize, cellY+labelHeight+weekThumbSize)
+		selectedImage, err := previewImage(mapper, date, region)
+		if err != nil {
+			errorLogger.Printf("Error selecting image for %s: %v", date.Format("2006-01-02"), err)
+			continue
+		}
+		if isVideo(selectedImage) {
+			draw.Draw(sprite, tile, image.NewUniform(color.RGBA{0x40, 0x40, 0x40, 0xff}), image.Point{}, draw.Src)
+			continue
+		}
+
+		path, err := sourcePath(selectedImage)
+		if err != nil {
+			errorLogger.Printf("Error fetching %s: %v", selectedImage, err)
+			continue
+		}
+		img, _, err := decodeImage(path)
+		if err != nil {
+			errorLogger.Printf("Error decoding %s: %v", selectedImage, err)
+			continue
+		}
+		thumb := scaleToFit(img, weekThumbSize, weekThumbSize)
+
+		// Center the thumbnail within its tile
+		offset := image.Pt((weekThumbSize-thumb.Bounds().Dx())/2, (weekThumbSize-thumb.Bounds().Dy())/2)
+		draw.Draw(sprite, thumb.Bounds().Add(tile.Min.Add(offset)), thumb, image.Point{}, draw.Over)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sprite); err != nil {
+		errorLogger.Printf("Error encoding week preview: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// weightsFile is the name of the optional file in the image directory that
+// assigns weights to images.
+const weightsFile = "weights.txt"
+
+// weightFromName returns the weight encoded in a filename suffix, e.g. 3 for
+// photo@3.jpg, or 1 if there is none.
+func weightFromName(name string) float64 {
+	stem := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	i := strings.LastIndex(stem, "@")
+	if i < 0 {
+		return 1
+	}
+	weight, err := strconv.ParseFloat(stem[i+1:], 64)
+	if err != nil || weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+// readWeights parses the weights file at path: one "filename weight" pair
+// per line, skipping blank lines and lines starting with #. A missing file
+// has no weights.
+func readWeights(path string) (map[string]float64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	weights := make(map[string]float64)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		// The weight is the last field, so filenames may contain spaces
+		i := strings.LastIndexAny(text, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected filename and weight", path, line)
+		}
+		weight, err := strconv.ParseFloat(text[i+1:], 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("%s:%d: invalid weight %q", path, line, text[i+1:])
+		}
+		weights[strings.TrimSpace(text[:i])] = weight
+	}
+	return weights, scanner.Err()
+}
+
+// applyWeights sets the weight of images from the weights file in dir,
+// which takes precedence over filename suffixes.
+func applyWeights(dir string, images []imageInfo) error {
+	weights, err := readWeights(filepath.Join(dir, weightsFile))
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		if weight, ok := weights[images[i].Filename]; ok {
+			images[i].Weight = weight
+		}
+	}
+	return nil
+}
+
+// imageWeights returns the weights of images for the ImageMapper, or nil if
+// they're all equal to 1.
+func imageWeights(images []imageInfo) map[string]float64 {
+	var weights map[string]float64
+	for _, image := range images {
+		if image.Weight == 1 || image.Weight == 0 {
+			continue
+		}
+		if weights == nil {
+			weights = make(map[string]float64)
+		}
+		weights[image.Filename] = image.Weight
+	}
+	return weights
+}
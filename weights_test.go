@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWeightFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"photo.jpg", 1},
+		{"photo@3.jpg", 3},
+		{"photo@0.5.png", 0.5},
+		{"nested/dir/photo@2.jpg", 2},
+		{"me@home@4.jpg", 4},
+		{"me@home.jpg", 1},
+		{"photo@0.jpg", 1},
+		{"photo@-2.jpg", 1},
+		{"photo@.jpg", 1},
+		{"dir@3/photo.jpg", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := weightFromName(tt.name); got != tt.want {
+				t.Errorf("weightFromName(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"empty", "", map[string]float64{}, false},
+		{"pairs", "a.jpg 3\nb.jpg\t0.5\n", map[string]float64{"a.jpg": 3, "b.jpg": 0.5}, false},
+		{"comments and blank lines", "# favorites\n\n  a.jpg 2  \n", map[string]float64{"a.jpg": 2}, false},
+		{"spaces in names", "summer holiday.jpg 4\n", map[string]float64{"summer holiday.jpg": 4}, false},
+		{"nested", "album/a.jpg 2\n", map[string]float64{"album/a.jpg": 2}, false},
+		{"missing weight", "a.jpg\n", nil, true},
+		{"invalid weight", "a.jpg lots\n", nil, true},
+		{"zero weight", "a.jpg 0\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), weightsFile, []byte(tt.content))
+			got, err := readWeights(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readWeights() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("readWeights() = %v, want %v", got, tt.want)
+			}
+			for name, weight := range tt.want {
+				if got[name] != weight {
+					t.Errorf("weight of %q = %v, want %v", name, got[name], weight)
+				}
+			}
+		})
+	}
+
+	if got, err := readWeights(filepath.Join(t.TempDir(), weightsFile)); err != nil || got != nil {
+		t.Errorf("readWeights() of a missing file = %v, %v, want no weights", got, err)
+	}
+}
+
+func TestApplyWeights(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, weightsFile, []byte("b@2.jpg 5\nmissing.jpg 3\n"))
+	images := []imageInfo{
+		{Filename: "a.jpg", Weight: weightFromName("a.jpg")},
+		{Filename: "b@2.jpg", Weight: weightFromName("b@2.jpg")},
+		{Filename: "c@4.jpg", Weight: weightFromName("c@4.jpg")},
+	}
+	if err := applyWeights(dir, images); err != nil {
+		t.Fatal(err)
+	}
+	// The weights file takes precedence over the suffix
+	want := []float64{1, 5, 4}
+	for i, image := range images {
+		if image.Weight != want[i] {
+			t.Errorf("weight of %s = %v, want %v", image.Filename, image.Weight, want[i])
+		}
+	}
+}
+
+func TestWeightedShare(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]float64
+		days    int
+		want    map[string]float64 // Share of dates
+	}{
+		{"equal", nil, 4000, map[string]float64{"a.jpg": 0.25, "b.jpg": 0.25, "c.jpg": 0.25, "d.jpg": 0.25}},
+		{"three to one", map[string]float64{"a.jpg": 3}, 4000, map[string]float64{"a.jpg": 0.5, "b.jpg": 1.0 / 6}},
+		{"fractional", map[string]float64{"a.jpg": 0.5, "b.jpg": 0.5}, 4000, map[string]float64{"a.jpg": 1.0 / 6, "c.jpg": 1.0 / 3}},
+	}
+	images := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := NewImageMapper(images).WithWeights(tt.weights).WithClock(func() time.Time { return epoch.AddDate(0, 0, tt.days) })
+			counts := make(map[string]int)
+			for day := 0; day < tt.days; day++ {
+				got, err := mapper.GetImageForDate(epoch.AddDate(0, 0, day))
+				if err != nil {
+					t.Fatal(err)
+				}
+				counts[got]++
+			}
+			for name, share := range tt.want {
+				if got := float64(counts[name]) / float64(tt.days); math.Abs(got-share) > 0.025 {
+					t.Errorf("%s picked on %.3f of dates, want %.3f", name, got, share)
+				}
+			}
+		})
+	}
+
+	// Over a single year, a weight-3 image wins more dates than any of
+	// weight 1
+	names := testImages(10)
+	mapper := NewImageMapper(names).WithWeights(map[string]float64{names[0]: 3})
+	counts := make(map[string]int)
+	for day := 0; day < 365; day++ {
+		got, err := mapper.GetImageForDate(mustDate(t, "2024-01-01").AddDate(0, 0, day))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[got]++
+	}
+	for _, name := range names[1:] {
+		if counts[name] >= counts[names[0]] {
+			t.Errorf("%s won %d dates, as many as %s of weight 3 with %d", name, counts[name], names[0], counts[names[0]])
+		}
+	}
+}